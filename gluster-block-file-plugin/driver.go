@@ -1,14 +1,14 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
 	"errors"
 	"fmt"
-	"io/ioutil"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/docker/go-plugins-helpers/volume"
 	"github.com/sirupsen/logrus"
@@ -19,6 +19,9 @@ import (
 const defaultFileFormat = "%s.img"
 const defaultFilesystem = "xfs"
 
+// hostname names the records of this host in the gluster state store.
+var hostname, _ = os.Hostname()
+
 type BlockFileConfig struct {
 	filesystem     string
 	filenameFormat string
@@ -29,9 +32,41 @@ type GlusterBlockVolume struct {
 	glusterfsvolume.MountedVolume
 	GlusterVolumeId string
 	ImagePath       string
+	// OnRemove is the policy applied to the block file on removal, the
+	// plugin one if empty.
+	OnRemove string `json:",omitempty"`
+	// ClonedFrom is the volume the block file was copied from.
+	ClonedFrom string `json:",omitempty"`
+	// InitFrom is the template the filesystem was populated with.
+	InitFrom string `json:",omitempty"`
+	// Discovered is set when the volume was adopted from a block file found
+	// on the gluster volume.
+	Discovered bool `json:",omitempty"`
+	// Unmounted is set on adopted volumes until their first Mount, so that a
+	// block file maybe still used by its original host is not mounted by
+	// Reconcile or the monitor before a container needs it.
+	Unmounted bool `json:",omitempty"`
+}
+
+// IsMounted tells whether the block file is loop mounted on the mount point.
+func (gbv *GlusterBlockVolume) IsMounted() bool {
+	m, err := glusterfsvolume.FindMount(gbv.Mountpoint)
+	if err != nil {
+		logrus.Errorf("Failed to read mount table: %v", err)
+		return false
+	}
+	if m == nil {
+		return false
+	}
+	if !strings.HasPrefix(m.Source, "/dev/loop") {
+		logrus.WithField("mountpoint", gbv.Mountpoint).Warnf(
+			"'%v' mounted instead of block file '%v'", m.Source, gbv.ImagePath)
+		return false
+	}
+	return true
 }
 
-func (gbv *GlusterBlockVolume) Mount() error {
+func (gbv *GlusterBlockVolume) Mount(ctx context.Context) error {
 	if gbv.IsMounted() {
 		return nil
 	}
@@ -40,52 +75,127 @@ func (gbv *GlusterBlockVolume) Mount() error {
 		return fmt.Errorf("error creating mount point: %v)", err)
 	}
 
-	if output, err := ExecuteCommand("mount", gbv.ImagePath, gbv.Mountpoint); err != nil {
-		return fmt.Errorf("mount command execute failed: %v (%s)", err, output)
+	if output, err := ExecuteCommand(ctx, "mount", gbv.ImagePath, gbv.Mountpoint); err != nil {
+		return fmt.Errorf("mount command execute failed: %w (%s)", err, output)
 	}
 	return nil
 }
 
-func (gbv *GlusterBlockVolume) createBlockFile(size string, filesystem string) error {
+// createBlockFile creates and formats the block file, existing files are
+// reused as is. It returns whether the file was created.
+func (gbv *GlusterBlockVolume) createBlockFile(ctx context.Context, size string, filesystem string) (bool, error) {
 	info, err := os.Stat(gbv.ImagePath)
 	if err == nil {
 		if info.IsDir() {
-			return fmt.Errorf("'%v' should be a file, not a dir", gbv.ImagePath)
+			return false, fmt.Errorf("'%v' should be a file, not a dir", gbv.ImagePath)
 		}
-		return nil
+		return false, nil
 	}
 
 	if size == "" {
-		return errors.New("'default-size' option at driver level or 'size' option should be defined")
+		return false, errors.New("'default-size' option at driver level or 'size' option should be defined")
 	}
 
-	output, err := ExecuteCommand("truncate", "-s", size, gbv.ImagePath)
+	output, err := ExecuteCommand(ctx, "truncate", "-s", size, gbv.ImagePath)
 	if err != nil {
-		return fmt.Errorf("Image file '%v' creation failed: %v (%s)", gbv.ImagePath, err, output)
+		return false, fmt.Errorf("Image file '%v' creation failed: %w (%s)", gbv.ImagePath, err, output)
 	}
 
-	output, err = ExecuteCommand("mkfs."+filesystem, gbv.ImagePath)
+	output, err = ExecuteCommand(ctx, "mkfs."+filesystem, gbv.ImagePath)
 	if err != nil {
-		return fmt.Errorf("Error creating filsystem '%v': %v (%s)", filesystem, err, output)
+		return false, fmt.Errorf("Error creating filsystem '%v': %w (%s)", filesystem, err, output)
+	}
+
+	return true, nil
+}
+
+// cloneBlockFile copies the block file of source, frozen meanwhile if
+// mounted. XFS filesystems get a new UUID, so that both can be mounted. The
+// copy is removed on error, so that the clone can be retried.
+func (gbv *GlusterBlockVolume) cloneBlockFile(ctx context.Context, source *GlusterBlockVolume) (err error) {
+	if _, err := os.Lstat(gbv.ImagePath); err == nil {
+		return fmt.Errorf("'%v' already exists, can not clone into it", gbv.ImagePath)
 	}
 
+	if source.IsMounted() {
+		if output, err := ExecuteCommand(ctx, "fsfreeze", "-f", source.Mountpoint); err != nil {
+			return fmt.Errorf("fsfreeze command execute failed: %w (%s)", err, output)
+		}
+		defer func() {
+			// thawed even when ctx is done.
+			if output, err := ExecuteCommand(context.Background(), "fsfreeze", "-u", source.Mountpoint); err != nil {
+				logrus.WithField("mountpoint", source.Mountpoint).Errorf(
+					"fsfreeze command execute failed: %s (%s)", err, output)
+			}
+		}()
+	}
+
+	defer func() {
+		if err != nil {
+			os.Remove(gbv.ImagePath)
+		}
+	}()
+
+	output, err := ExecuteCommand(ctx, "cp", "--reflink=auto", "--sparse=always", source.ImagePath, gbv.ImagePath)
+	if err != nil {
+		return fmt.Errorf("cp command execute failed: %w (%s)", err, output)
+	}
+
+	output, err = ExecuteCommand(ctx, "blkid", "-o", "value", "-s", "TYPE", gbv.ImagePath)
+	if err != nil {
+		return fmt.Errorf("blkid command execute failed: %w (%s)", err, output)
+	}
+	if strings.TrimSpace(string(output)) == "xfs" {
+		if output, err := ExecuteCommand(ctx, "xfs_admin", "-U", "generate", gbv.ImagePath); err != nil {
+			return fmt.Errorf("xfs_admin command execute failed: %w (%s)", err, output)
+		}
+	}
 	return nil
 }
 
+// removeClone removes the block file of a volume being cloned, so that the
+// clone is not refused on retry.
+func (gbv *GlusterBlockVolume) removeClone() {
+	if gbv.ClonedFrom == "" {
+		return
+	}
+	if err := os.Remove(gbv.ImagePath); err != nil {
+		logrus.WithField("image", gbv.ImagePath).Warnf("Error removing cloned block file: %s", err)
+	}
+}
+
+// stateVersion is the version of the persisted State, bump it and register a
+// migration in stateMigrations when changing the persisted fields.
+const stateVersion = 1
+
+var stateMigrations = map[int]glusterfsvolume.Migration{}
+
 type State struct {
+	Version int
+
 	GlusterBlockVolumes map[string]*GlusterBlockVolume
 	GlusterVolumes      glusterfsvolume.State
+	// Trashes are the gluster volumes with trashed block files.
+	Trashes glusterfsvolume.Trashes `json:",omitempty"`
 }
 
-func (state *State) deleteUnused(gvId string) error {
+// isReferenced tells whether a block file is stored on the gluster volume.
+func (state *State) isReferenced(gvId string) bool {
 	for _, v := range state.GlusterBlockVolumes {
 		if v.GlusterVolumeId == gvId {
-			return nil
+			return true
 		}
 	}
+	return false
+}
+
+func (state *State) deleteUnused(ctx context.Context, gvId string) error {
+	if state.isReferenced(gvId) {
+		return nil
+	}
 
 	gv := state.GlusterVolumes[gvId]
-	if err := gv.Unmount(); err != nil {
+	if err := gv.Unmount(ctx); err != nil {
 		return err
 	}
 	if err := gv.DeleteMountpoint(); err != nil {
@@ -97,19 +207,104 @@ func (state *State) deleteUnused(gvId string) error {
 	return nil
 }
 
-type Driver struct {
-	sync.Mutex
+func (state *State) volumeStatus(v *GlusterBlockVolume) map[string]interface{} {
+	gv, ok := state.GlusterVolumes[v.GlusterVolumeId]
+	if !ok {
+		return map[string]interface{}{"degraded": "gluster volume missing from state"}
+	}
+	status := gv.Status()
+	if v.Health.Degraded() && !gv.Health.Degraded() {
+		status = v.Health.Status()
+	}
+	if status == nil {
+		status = map[string]interface{}{}
+	}
+	if v.ClonedFrom != "" {
+		status["cloned-from"] = v.ClonedFrom
+	}
+	if v.InitFrom != "" {
+		status["init-from"] = v.InitFrom
+	}
+	if v.Discovered {
+		status["origin"] = "discovered"
+	}
+	if len(status) == 0 {
+		return nil
+	}
+	return status
+}
 
-	root      string
-	statePath string
+type Driver struct {
+	// mu protects state and the uses in progress of mounts, it is only held
+	// while reading or updating them, never while mounting or formatting.
+	// Locks are taken in this order: volumeLocks, the mount locks, mu.
+	mu sync.Mutex
+	// volumeLocks serializes the operations on a docker volume.
+	volumeLocks glusterfsvolume.KeyedRWMutex
+	// mounts shares the gluster mounts between block files.
+	mounts glusterfsvolume.Mounts
+
+	root  string
+	store glusterfsvolume.StateStore
+	// ctx is done when the plugin stops, killing the commands of requests in
+	// progress. Background if nil.
+	ctx context.Context
 
 	glusterConfig   glusterfsvolume.Config
 	blockFileConfig BlockFileConfig
 	state           State
+
+	// monitorInterval is the delay between two checks of the mounts, 0
+	// disables the monitor.
+	monitorInterval time.Duration
+	// removePolicy is applied to the block files of removed volumes without
+	// their own policy, retain if empty. trashRetention is how long trashed
+	// block files are kept, 0 keeps them forever.
+	removePolicy   string
+	trashRetention time.Duration
+	// discover lists the block files of the plugin gluster volume matching
+	// the filename format as volumes. They are adopted when first inspected
+	// if adopt is set, otherwise they are left to their owner.
+	discover bool
+	adopt    bool
+	// discovery caches the block files listed.
+	discovery glusterfsvolume.Discovery
 }
 
-var ExecuteCommand = func(cmd string, args ...string) ([]byte, error) {
-	return exec.Command(cmd, args...).CombinedOutput()
+// mountState gives d.mounts access to the state of the driver.
+type mountState struct{ d *Driver }
+
+func (s mountState) Lock()   { s.d.mu.Lock() }
+func (s mountState) Unlock() { s.d.mu.Unlock() }
+
+func (s mountState) GlusterVolumes() glusterfsvolume.State {
+	return s.d.state.GlusterVolumes
+}
+
+// Used tells whether a block file is stored on the gluster volume.
+func (s mountState) Used(gvId string) bool {
+	return s.d.state.isReferenced(gvId)
+}
+
+func (s mountState) Forget(gvId string) (bool, error) {
+	if s.d.state.isReferenced(gvId) {
+		return false, nil
+	}
+	delete(s.d.state.GlusterVolumes, gvId)
+	return true, s.d.saveState()
+}
+
+var ExecuteCommand = func(ctx context.Context, cmd string, args ...string) ([]byte, error) {
+	return glusterfsvolume.ExecuteCommand(ctx, cmd, args...)
+}
+
+// requestContext returns the context of the requests, done when the plugin
+// stops.
+func (d *Driver) requestContext() context.Context {
+	if d.ctx == nil {
+		return context.Background()
+	}
+	return d.ctx
 }
 
 func (d *Driver) Capabilities() *volume.CapabilitiesResponse {
@@ -120,11 +315,19 @@ func (d *Driver) Capabilities() *volume.CapabilitiesResponse {
 
 func (d *Driver) Create(r *volume.CreateRequest) error {
 	logrus.WithField("method", "create").Debugf("%#v", r)
+	ctx := d.requestContext()
+
+	// the volume cloned is read locked until the copy is done.
+	from := r.Options["from"]
+	if from == r.Name {
+		return fmt.Errorf("volume %s can not be cloned from itself", r.Name)
+	}
+	unlock := d.volumeLocks.LockWithRLock(r.Name, from)
+	defer unlock()
 
-	d.Lock()
-	defer d.Unlock()
 	glusterConf := d.glusterConfig.Copy()
 	blockFileConf := d.blockFileConfig
+	setup := volumeSetup{}
 
 	const optionSetError = "'%v' option already set by driver, can not override."
 
@@ -142,6 +345,8 @@ func (d *Driver) Create(r *volume.CreateRequest) error {
 			glusterConf.VolumeName = val
 		case "dedicated-mount":
 			glusterConf.DedicatedMount = true
+		case "mount-group":
+			glusterConf.MountGroup = val
 		case "filename-format":
 			if blockFileConf.filenameFormat != "" {
 				return fmt.Errorf(optionSetError, key)
@@ -154,36 +359,69 @@ func (d *Driver) Create(r *volume.CreateRequest) error {
 			blockFileConf.filesystem = val
 		case "size":
 			blockFileConf.size = val
-		default:
-			if err := glusterfsvolume.CheckOption(key, val); err != nil {
+		case "on-remove":
+			if err := glusterfsvolume.CheckRemovePolicy(val); err != nil {
+				return err
+			}
+			setup.onRemove = val
+		case "from":
+			setup.from = val
+		case "init-from":
+			if err := glusterfsvolume.CheckInitFrom(val); err != nil {
 				return err
 			}
-			if len(d.glusterConfig.Options) != 0 {
-				return errors.New("Gluster options already set by driver, can not override.")
+			setup.initFrom = val
+		default:
+			if err := glusterConf.Override(key, val); err != nil {
+				return err
 			}
-			glusterConf.Options[key] = val
 		}
 	}
 
-	id, err := d.state.GlusterVolumes.GetOrCreateVolume(glusterConf, filepath.Join(d.root, "gluster-volumes"))
-	if err != nil {
-		return err
+	if setup.from != "" {
+		if setup.initFrom != "" {
+			return errors.New("'from' and 'init-from' options are exclusive")
+		}
+		d.mu.Lock()
+		setup.source = d.state.GlusterBlockVolumes[setup.from]
+		d.mu.Unlock()
+		if setup.source == nil {
+			return fmt.Errorf("volume %s not found", setup.from)
+		}
 	}
 
-	defer d.saveState()
-	defer d.state.deleteUnused(id)
+	return d.useGlusterVolume(ctx, glusterConf, func(id string, gv *glusterfsvolume.GlusterfsVolume) error {
+		return d.createVolume(ctx, r.Name, id, gv, blockFileConf, setup)
+	})
+}
 
-	gv := d.state.GlusterVolumes[id]
+// useGlusterVolume calls fn with the gluster volume of conf mounted, and
+// releases it afterwards if unused.
+func (d *Driver) useGlusterVolume(ctx context.Context, conf glusterfsvolume.Config, fn func(id string, gv *glusterfsvolume.GlusterfsVolume) error) error {
+	return d.mounts.Use(ctx, mountState{d}, conf, filepath.Join(d.root, "gluster-volumes"), fn)
+}
 
-	if err := gv.Mount(); err != nil {
-		return err
-	}
+// volumeSetup holds the create options of a docker volume that are not part
+// of its gluster or block file configuration.
+type volumeSetup struct {
+	onRemove string
+	// from is the name of the volume to clone, source its state.
+	from     string
+	source   *GlusterBlockVolume
+	initFrom string
+	// discovered is set when adopting an existing block file, which is then
+	// never created.
+	discovered bool
+}
 
+// createVolume creates and mounts the block file of the docker volume on the
+// mounted gluster volume gvId, as a copy of the one of setup.source if set.
+func (d *Driver) createVolume(ctx context.Context, name, gvId string, gv *glusterfsvolume.GlusterfsVolume, blockFileConf BlockFileConfig, setup volumeSetup) error {
 	var filename string
 	if blockFileConf.filenameFormat == "" {
-		filename = fmt.Sprintf(defaultFileFormat, r.Name)
+		filename = fmt.Sprintf(defaultFileFormat, name)
 	} else {
-		filename = fmt.Sprintf(blockFileConf.filenameFormat, r.Name)
+		filename = fmt.Sprintf(blockFileConf.filenameFormat, name)
 	}
 
 	filesystem := blockFileConf.filesystem
@@ -192,62 +430,227 @@ func (d *Driver) Create(r *volume.CreateRequest) error {
 	}
 
 	blockVolume := &GlusterBlockVolume{
-		GlusterVolumeId: id,
+		GlusterVolumeId: gvId,
 		ImagePath:       filepath.Join(gv.Mountpoint, filename),
+		OnRemove:        setup.onRemove,
+		Discovered:      setup.discovered,
 		MountedVolume: glusterfsvolume.MountedVolume{
-			Mountpoint: filepath.Join(d.root, "block-file-volumes", r.Name)},
+			Mountpoint: filepath.Join(d.root, "block-file-volumes", name)},
+	}
+
+	if setup.discovered {
+		// the block file may have been removed since it was discovered.
+		if _, err := os.Stat(blockVolume.ImagePath); err != nil {
+			return fmt.Errorf("Error adopting block file: %w", err)
+		}
+		// it may still be mounted by its original host, it is only mounted
+		// once a container needs it.
+		blockVolume.Unmounted = true
+
+		d.mu.Lock()
+		defer d.mu.Unlock()
+
+		d.state.GlusterBlockVolumes[name] = blockVolume
+		return d.saveState()
 	}
 
-	if err := blockVolume.createBlockFile(blockFileConf.size, filesystem); err != nil {
-		return fmt.Errorf("Error creating block file: %v", err)
+	created := false
+	if setup.source != nil {
+		if err := d.cloneVolume(ctx, blockVolume, gvId, setup.source); err != nil {
+			return fmt.Errorf("Error cloning volume %s: %w", setup.from, err)
+		}
+		blockVolume.ClonedFrom = setup.from
+	} else {
+		var err error
+		if created, err = blockVolume.createBlockFile(ctx, blockFileConf.size, filesystem); err != nil {
+			return fmt.Errorf("Error creating block file: %w", err)
+		}
 	}
 
 	if err := blockVolume.CreateMountpoint(); err != nil {
+		blockVolume.removeClone()
 		return fmt.Errorf("Error creating mount point: %v", err)
 	}
 
-	if err := blockVolume.Mount(); err != nil {
-		return fmt.Errorf("Error mounting block file: %v", err)
+	if err := blockVolume.Mount(ctx); err != nil {
+		blockVolume.removeClone()
+		return fmt.Errorf("Error mounting block file: %w", err)
+	}
 
+	if setup.initFrom != "" {
+		if !created {
+			logrus.WithField("volume", name).Warnf(
+				"Block file '%v' already exists, not initializing it from '%v'", blockVolume.ImagePath, setup.initFrom)
+		} else if err := glusterfsvolume.InitDir(ctx, gv.Mountpoint, setup.initFrom, blockVolume.Mountpoint); err != nil {
+			// so that the filesystem is formatted and populated again on retry.
+			if unmountErr := blockVolume.Unmount(ctx); unmountErr != nil {
+				logrus.WithField("volume", name).Warnf("Error unmounting block file: %s", unmountErr)
+			} else {
+				os.Remove(blockVolume.ImagePath)
+			}
+			return fmt.Errorf("Error initializing volume from '%v': %w", setup.initFrom, err)
+		} else {
+			blockVolume.InitFrom = setup.initFrom
+		}
 	}
 
-	d.state.GlusterBlockVolumes[r.Name] = blockVolume
+	d.mu.Lock()
+	defer d.mu.Unlock()
 
-	return nil
+	d.state.GlusterBlockVolumes[name] = blockVolume
+	return d.saveState()
+}
+
+// cloneVolume copies the block file of source to the one of v, stored on
+// the mounted gluster volume gvId.
+func (d *Driver) cloneVolume(ctx context.Context, v *GlusterBlockVolume, gvId string, source *GlusterBlockVolume) error {
+	if source.GlusterVolumeId != gvId {
+		unlock := d.mounts.RLock(source.GlusterVolumeId)
+		defer unlock()
+
+		d.mu.Lock()
+		sourceGv, ok := d.state.GlusterVolumes[source.GlusterVolumeId]
+		d.mu.Unlock()
+		if !ok {
+			return fmt.Errorf("Gluster Volume %s not found", source.GlusterVolumeId)
+		}
+		if err := d.mounts.Mount(ctx, mountState{d}, source.GlusterVolumeId, sourceGv); err != nil {
+			return fmt.Errorf("Error mounting Gluster Volume: %w", err)
+		}
+	}
+	return v.cloneBlockFile(ctx, source)
 }
 
 func (d *Driver) Get(r *volume.GetRequest) (*volume.GetResponse, error) {
 	logrus.WithField("method", "get").Debugf("%#v", r)
+	ctx := d.requestContext()
+
+	discovered, err := d.discoverVolume(ctx, r.Name)
+	if err != nil {
+		return &volume.GetResponse{}, err
+	}
 
-	d.Lock()
-	defer d.Unlock()
+	d.mu.Lock()
+	defer d.mu.Unlock()
 
 	v, ok := d.state.GlusterBlockVolumes[r.Name]
+	if !ok && discovered {
+		// listed, but left to its owner.
+		return &volume.GetResponse{Volume: &volume.Volume{
+			Name:   r.Name,
+			Status: map[string]interface{}{"origin": "discovered", "adopted": false},
+		}}, nil
+	}
 	if !ok {
 		return &volume.GetResponse{}, fmt.Errorf("volume %s not found", r.Name)
 	}
 
-	return &volume.GetResponse{Volume: &volume.Volume{Name: r.Name, Mountpoint: v.Mountpoint}}, nil
+	return &volume.GetResponse{Volume: &volume.Volume{
+		Name:       r.Name,
+		Mountpoint: v.Mountpoint,
+		Status:     d.state.volumeStatus(v),
+	}}, nil
 }
 
 func (d *Driver) List() (*volume.ListResponse, error) {
 	logrus.WithField("method", "list").Debugf("")
+	ctx := d.requestContext()
 
-	d.Lock()
-	defer d.Unlock()
+	var discovered []string
+	if d.discover {
+		var err error
+		if discovered, err = d.discoverVolumes(ctx); err != nil {
+			logrus.WithField("method", "list").Warnf("Error discovering volumes: %s", err)
+		}
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
 
 	var vols []*volume.Volume
 	for name, v := range d.state.GlusterBlockVolumes {
 		vols = append(vols, &volume.Volume{Name: name, Mountpoint: v.Mountpoint})
 	}
+	// discovered volumes have no mount point until adopted.
+	for _, name := range discovered {
+		if _, ok := d.state.GlusterBlockVolumes[name]; !ok {
+			vols = append(vols, &volume.Volume{Name: name})
+		}
+	}
 	return &volume.ListResponse{Volumes: vols}, nil
 }
 
+// filenameFormat is the format of the block file names of the plugin.
+func (d *Driver) filenameFormat() string {
+	if d.blockFileConfig.filenameFormat == "" {
+		return defaultFileFormat
+	}
+	return d.blockFileConfig.filenameFormat
+}
+
+// discoverVolumes returns the volumes of the block files found at the root
+// of the plugin gluster volume, which is only mounted once the names cached
+// expire.
+func (d *Driver) discoverVolumes(ctx context.Context) ([]string, error) {
+	return d.discovery.Names(glusterfsvolume.DiscoveryMaxAge, func() ([]string, error) {
+		var names []string
+		err := d.useGlusterVolume(ctx, d.glusterConfig.Copy(), func(id string, gv *glusterfsvolume.GlusterfsVolume) error {
+			var err error
+			names, err = glusterfsvolume.DiscoverFiles(gv.Mountpoint, d.filenameFormat())
+			return err
+		})
+		return names, err
+	})
+}
+
+// discoverVolume tells whether the block file of the unknown volume name is
+// found on the plugin gluster volume when discovery is enabled, and adopts
+// it if adoption is enabled too. The block file is not mounted until Mount.
+func (d *Driver) discoverVolume(ctx context.Context, name string) (bool, error) {
+	if !d.discover {
+		return false, nil
+	}
+
+	unlock := d.volumeLocks.Lock(name)
+	defer unlock()
+
+	d.mu.Lock()
+	_, exists := d.state.GlusterBlockVolumes[name]
+	d.mu.Unlock()
+	if exists {
+		return false, nil
+	}
+
+	discovered := false
+	err := d.useGlusterVolume(ctx, d.glusterConfig.Copy(), func(id string, gv *glusterfsvolume.GlusterfsVolume) error {
+		found, err := glusterfsvolume.IsFileDiscovered(gv.Mountpoint, d.filenameFormat(), name)
+		if err != nil {
+			return fmt.Errorf("Error discovering volume %s: %w", name, err)
+		}
+		discovered = found
+		if !found || !d.adopt {
+			return nil
+		}
+
+		if err := d.createVolume(ctx, name, id, gv, d.blockFileConfig, volumeSetup{discovered: true}); err != nil {
+			return fmt.Errorf("Error adopting volume %s: %w", name, err)
+		}
+		logrus.WithField("volume", name).Info("Discovered volume adopted")
+		return nil
+	})
+	return discovered, err
+}
+
 func (d *Driver) Path(r *volume.PathRequest) (*volume.PathResponse, error) {
 	logrus.WithField("method", "path").Debugf("%#v", r)
+	ctx := d.requestContext()
+
+	if _, err := d.discoverVolume(ctx, r.Name); err != nil {
+		return &volume.PathResponse{}, err
+	}
 
-	d.Lock()
-	defer d.Unlock()
+	d.mu.Lock()
+	defer d.mu.Unlock()
 
 	v, ok := d.state.GlusterBlockVolumes[r.Name]
 	if !ok {
@@ -259,18 +662,53 @@ func (d *Driver) Path(r *volume.PathRequest) (*volume.PathResponse, error) {
 
 func (d *Driver) Mount(r *volume.MountRequest) (*volume.MountResponse, error) {
 	logrus.WithField("method", "mount").Debugf("%#v", r)
+	ctx := d.requestContext()
+
+	unlock := d.volumeLocks.Lock(r.Name)
+	defer unlock()
 
+	d.mu.Lock()
 	v, ok := d.state.GlusterBlockVolumes[r.Name]
+	var gv *glusterfsvolume.GlusterfsVolume
+	if ok {
+		logrus.WithField("method", "mount").Debugf("found volume %#v", v)
+		gv = d.state.GlusterVolumes[v.GlusterVolumeId]
+	}
+	d.mu.Unlock()
+
+	if !ok && d.discover && !d.adopt {
+		return &volume.MountResponse{}, fmt.Errorf(
+			"volume %s not found, discovered volumes are only mounted once adopted with 'discover=adopt'", r.Name)
+	}
 	if !ok {
 		return &volume.MountResponse{}, fmt.Errorf("volume %s not found", r.Name)
 	}
-	logrus.WithField("method", "mount").Debugf("found volume %#v", v)
+	if gv == nil {
+		return &volume.MountResponse{}, fmt.Errorf("Gluster Volume %s not found", v.GlusterVolumeId)
+	}
 
-	if err := d.state.GlusterVolumes[v.GlusterVolumeId].Mount(); err != nil {
-		return &volume.MountResponse{}, fmt.Errorf("Error mounting Gluster Volume: %s", err)
+	unlockMount := d.mounts.RLock(v.GlusterVolumeId)
+	defer unlockMount()
+
+	if err := d.mounts.Mount(ctx, mountState{d}, v.GlusterVolumeId, gv); err != nil {
+		return &volume.MountResponse{}, fmt.Errorf("Error mounting Gluster Volume: %w", err)
 	}
-	if err := v.Mount(); err != nil {
-		return &volume.MountResponse{}, fmt.Errorf("Error mounting Block File: %s", err)
+
+	err := v.Mount(ctx)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err != nil {
+		v.Health.Failed(err)
+		return &volume.MountResponse{}, fmt.Errorf("Error mounting Block File: %w", err)
+	}
+	v.Health.Recovered()
+	if v.Unmounted {
+		v.Unmounted = false
+		if err := d.saveState(); err != nil {
+			logrus.WithField("volume", r.Name).Warnf("Error saving first mount of adopted volume: %s", err)
+		}
 	}
 
 	return &volume.MountResponse{Mountpoint: v.Mountpoint}, nil
@@ -279,6 +717,9 @@ func (d *Driver) Mount(r *volume.MountRequest) (*volume.MountResponse, error) {
 func (d *Driver) Unmount(r *volume.UnmountRequest) error {
 	logrus.WithField("method", "unmount").Debugf("%#v", r)
 
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
 	if _, ok := d.state.GlusterBlockVolumes[r.Name]; !ok {
 		return fmt.Errorf("volume %s not found", r.Name)
 	}
@@ -288,67 +729,218 @@ func (d *Driver) Unmount(r *volume.UnmountRequest) error {
 
 func (d *Driver) Remove(r *volume.RemoveRequest) error {
 	logrus.WithField("method", "remove").Debugf("%#v", r)
+	ctx := d.requestContext()
 
-	d.Lock()
-	defer d.Unlock()
+	unlock := d.volumeLocks.Lock(r.Name)
+	defer unlock()
 
+	d.mu.Lock()
 	v, ok := d.state.GlusterBlockVolumes[r.Name]
+	d.mu.Unlock()
 	if !ok {
 		return fmt.Errorf("volume %s not found", r.Name)
 	}
 
-	if err := v.Unmount(); err != nil {
-		return fmt.Errorf("Failed to unmount block file: %s", err)
+	if err := d.removeVolume(ctx, r.Name, v); err != nil {
+		return err
+	}
+
+	return d.mounts.Release(ctx, mountState{d}, v.GlusterVolumeId)
+}
+
+// removeVolume unmounts the block file of a docker volume, applies the
+// removal policy to it and forgets it.
+func (d *Driver) removeVolume(ctx context.Context, name string, v *GlusterBlockVolume) error {
+	unlock := d.mounts.RLock(v.GlusterVolumeId)
+	defer unlock()
+
+	if err := v.Unmount(ctx); err != nil {
+		return fmt.Errorf("Failed to unmount block file: %w", err)
 	}
 
 	if err := v.DeleteMountpoint(); err != nil {
 		logrus.Warnf("Error deleting block file mount point: %s", err)
 	}
 
-	gvId := v.GlusterVolumeId
-	delete(d.state.GlusterBlockVolumes, r.Name)
+	policy := v.OnRemove
+	if policy == "" {
+		policy = d.removePolicy
+	}
+	var trashed *glusterfsvolume.GlusterfsVolume
+	if policy != "" && policy != glusterfsvolume.RetainData {
+		d.mu.Lock()
+		gv, ok := d.state.GlusterVolumes[v.GlusterVolumeId]
+		d.mu.Unlock()
+		if !ok {
+			return fmt.Errorf("Gluster Volume %s not found", v.GlusterVolumeId)
+		}
+		if err := d.mounts.Mount(ctx, mountState{d}, v.GlusterVolumeId, gv); err != nil {
+			return fmt.Errorf("Error mounting Gluster Volume: %w", err)
+		}
+		if err := glusterfsvolume.RemoveData(gv.Mountpoint, v.ImagePath, policy); err != nil {
+			return fmt.Errorf("Error removing block file: %w", err)
+		}
+		// its block file may be listed until then.
+		d.discovery.Reset()
+		if policy == glusterfsvolume.TrashData && d.trashRetention > 0 {
+			if _, err := glusterfsvolume.PurgeTrash(gv.Mountpoint, d.trashRetention); err != nil {
+				logrus.WithField("volume", v.GlusterVolumeId).Warnf("Error purging trash: %s", err)
+			}
+			trashed = gv
+		}
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
 
-	if err := d.state.deleteUnused(gvId); err != nil {
-		return err
+	delete(d.state.GlusterBlockVolumes, name)
+	if trashed != nil {
+		d.state.Trashes.Record(v.GlusterVolumeId, trashed)
 	}
+	return d.saveState()
+}
 
-	d.saveState()
+// purgeTrash deletes the block files trashed for longer than trashRetention
+// on the gluster volumes with trashed data.
+func (d *Driver) purgeTrash(ctx context.Context) {
+	d.mu.Lock()
+	trashes := d.state.Trashes.Copy()
+	d.mu.Unlock()
 
-	return nil
+	purged := d.mounts.PurgeTrashes(ctx, mountState{d}, filepath.Join(d.root, "gluster-volumes"),
+		trashes, d.glusterConfig.ResolveServers, d.trashRetention)
+	if len(purged) == 0 {
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.state.Trashes.Drop(purged)
+	if err := d.saveState(); err != nil {
+		logrus.WithField("method", "purgeTrash").Warnf("Error recording purged trash: %s", err)
+	}
 }
 
 func (d *Driver) LoadState() error {
-	logrus.WithField("method", "LoadState").Debugf("loading state from '%v'", d.statePath)
+	logrus.WithField("method", "LoadState").Debugf("loading state from '%v'", d.store)
 
-	data, err := ioutil.ReadFile(d.statePath)
-	if err != nil {
-		if os.IsNotExist(err) {
-			logrus.WithField("statePath", d.statePath).Debug("no state found")
-		} else {
-			return err
+	return d.store.Load(&d.state)
+}
+
+// Reconcile brings the mount table in line with the loaded state: every
+// gluster volume and block file listed in the state is remounted if needed.
+func (d *Driver) Reconcile(ctx context.Context) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	remounted, degraded := 0, 0
+	for id, gv := range d.state.GlusterVolumes {
+		if err := d.state.deleteUnused(ctx, id); err != nil {
+			logrus.WithField("volume", id).Warnf("Error releasing unused mount: %s", err)
 		}
-	} else {
-		if err := json.Unmarshal(data, &d.state); err != nil {
-			return err
+		if _, ok := d.state.GlusterVolumes[id]; !ok {
+			continue
+		}
+		mounted := gv.IsMounted()
+		// also records the server of existing mounts.
+		if err := gv.Mount(ctx); err != nil {
+			logrus.WithField("volume", id).Errorf("Error remounting: %s", err)
+			gv.Health.Failed(err)
+			continue
+		}
+		if !mounted {
+			remounted++
 		}
 	}
 
-	return nil
+	for name, v := range d.state.GlusterBlockVolumes {
+		gv, ok := d.state.GlusterVolumes[v.GlusterVolumeId]
+		if !ok {
+			logrus.WithField("volume", name).Errorf(
+				"Gluster Volume %s not found in state", v.GlusterVolumeId)
+			degraded++
+			continue
+		}
+		if gv.Health.Degraded() {
+			degraded++
+			continue
+		}
+		if v.Unmounted || v.IsMounted() {
+			continue
+		}
+		if err := v.Mount(ctx); err != nil {
+			logrus.WithField("volume", name).Errorf("Error remounting block file: %s", err)
+			v.Health.Failed(err)
+			degraded++
+			continue
+		}
+		remounted++
+	}
+
+	log := logrus.Infof
+	if degraded != 0 {
+		log = logrus.Warnf
+	}
+	log("Restored %d volumes (%d gluster mounts): %d remounted, %d degraded",
+		len(d.state.GlusterBlockVolumes), len(d.state.GlusterVolumes), remounted, degraded)
 }
 
-func (d *Driver) saveState() {
-	logrus.WithField("method", "saveState").Debugf(
-		"saving state %#v to '%v'", d.state, d.statePath)
-	data, err := json.Marshal(d.state)
-	if err != nil {
-		logrus.WithField("statePath", d.statePath).Error(err)
-		return
+// checkMounts checks the gluster mounts, and remounts the stale or missing
+// ones and then the block files stored on them.
+func (d *Driver) checkMounts(ctx context.Context) {
+	d.mounts.HealAll(ctx, mountState{d}, d.healBlockFiles)
+}
+
+// healBlockFiles remounts the block files stored on the gluster volume id if
+// they are stale or missing, or all of them once it was remounted.
+func (d *Driver) healBlockFiles(ctx context.Context, id string, remounted bool) {
+	d.mu.Lock()
+	volumes := map[string]*GlusterBlockVolume{}
+	for name, v := range d.state.GlusterBlockVolumes {
+		if v.GlusterVolumeId == id && !v.Unmounted {
+			volumes[name] = v
+		}
 	}
+	d.mu.Unlock()
+
+	for name, v := range volumes {
+		// block file was opened through the dead gluster client.
+		if remounted && v.IsMounted() {
+			if err := v.ForceUnmount(ctx); err != nil {
+				logrus.WithField("volume", name).Errorf("Error unmounting block file: %s", err)
+			}
+		}
+
+		d.mu.Lock()
+		vmv := v.MountedVolume
+		d.mu.Unlock()
 
-	if err := ioutil.WriteFile(d.statePath, data, 0644); err != nil {
-		logrus.WithField("savestate", d.statePath).Error(err)
+		if healed, err := vmv.Heal(ctx, func() error { return v.Mount(ctx) }); err != nil {
+			logrus.WithField("volume", name).Errorf(
+				"Error remounting block file, next retry at %v: %s", vmv.Health.NextRetry, err)
+		} else if healed {
+			logrus.WithField("volume", name).Warn("Block file remounted")
+		}
+
+		d.mu.Lock()
+		v.Health = vmv.Health
+		d.mu.Unlock()
 	}
+}
 
+// saveState persists the state, the caller must hold d.mu.
+func (d *Driver) saveState() error {
+	d.state.Version = stateVersion
+	return glusterfsvolume.SaveState(d.store, d.state)
+}
+
+func newStateFile(path string) glusterfsvolume.StateFile {
+	return glusterfsvolume.StateFile{
+		Path:       path,
+		Version:    stateVersion,
+		Migrations: stateMigrations,
+	}
 }
 
 func (d *Driver) GetOptions() map[string]string {
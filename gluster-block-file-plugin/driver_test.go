@@ -0,0 +1,313 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/docker/go-plugins-helpers/volume"
+
+	"github.com/origin-nexus/docker-volume-glusterfs/glusterfs-volume"
+)
+
+// commands records the commands run, with their arguments.
+type commands [][]string
+
+func (c *commands) exec(_ context.Context, cmd string, args ...string) ([]byte, error) {
+	*c = append(*c, append([]string{cmd}, args...))
+	return []byte{}, nil
+}
+
+// ran tells whether a command was run with arg.
+func (c commands) ran(cmd, arg string) bool {
+	for _, command := range c {
+		if command[0] != cmd {
+			continue
+		}
+		for _, a := range command[1:] {
+			if a == arg {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func newTestDriver(root string) *Driver {
+	return &Driver{
+		root:  root,
+		store: newStateFile(filepath.Join(root, "test-state.json")),
+		glusterConfig: glusterfsvolume.Config{
+			Servers:    "server1",
+			VolumeName: "myvol",
+		},
+		blockFileConfig: BlockFileConfig{size: "1G"},
+		state: State{
+			GlusterBlockVolumes: map[string]*GlusterBlockVolume{},
+			GlusterVolumes:      glusterfsvolume.State{},
+		},
+	}
+}
+
+func TestDiscovery(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "gluster-block-file-plugin-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	var c commands
+	glusterfsvolume.ExecuteCommand = c.exec
+
+	d := newTestDriver(tmpDir)
+	d.discover = true
+
+	if err := d.Create(&volume.CreateRequest{Name: "mine"}); err != nil {
+		t.Fatalf("Unexpected error '%v'", err)
+	}
+	root := filepath.Dir(d.state.GlusterBlockVolumes["mine"].ImagePath)
+	for _, file := range []string{"other.img", "notes.txt"} {
+		if err := ioutil.WriteFile(filepath.Join(root, file), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.MkdirAll(filepath.Join(root, glusterfsvolume.TrashDir, "dir.img"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	image := filepath.Join(root, "other.img")
+
+	resp, err := d.List()
+	if err != nil {
+		t.Fatalf("Unexpected error '%v'", err)
+	}
+	var names []string
+	for _, v := range resp.Volumes {
+		names = append(names, v.Name)
+	}
+	sort.Strings(names)
+	if !reflect.DeepEqual(names, []string{"mine", "other"}) {
+		t.Errorf("Unexpected volumes %v", names)
+	}
+
+	// without adoption, discovered volumes are left to their owner.
+	get, err := d.Get(&volume.GetRequest{Name: "other"})
+	if err != nil {
+		t.Fatalf("Unexpected error '%v'", err)
+	}
+	if expected := map[string]interface{}{"origin": "discovered", "adopted": false}; !reflect.DeepEqual(get.Volume.Status, expected) {
+		t.Errorf("Unexpected status %v", get.Volume.Status)
+	}
+	if _, err := d.Mount(&volume.MountRequest{Name: "other", ID: "container"}); err == nil {
+		t.Error("Mounting a volume not adopted should return error")
+	}
+	if _, ok := d.state.GlusterBlockVolumes["other"]; ok || c.ran("mount", image) {
+		t.Errorf("Volume not adopted should be left as is, commands %v", c)
+	}
+
+	// adopted volumes are only mounted by Mount.
+	d.adopt = true
+	get, err = d.Get(&volume.GetRequest{Name: "other"})
+	if err != nil {
+		t.Fatalf("Unexpected error '%v'", err)
+	}
+	v, ok := d.state.GlusterBlockVolumes["other"]
+	if !ok || !v.Discovered || !v.Unmounted || v.ImagePath != image || get.Volume.Status["origin"] != "discovered" {
+		t.Fatalf("Unexpected adopted volume %#v, status %v", v, get.Volume.Status)
+	}
+	d.Reconcile(context.Background())
+	if c.ran("mount", image) || c.ran("truncate", image) {
+		t.Errorf("Adopted block file should not be mounted nor created, commands %v", c)
+	}
+
+	if _, err := d.Mount(&volume.MountRequest{Name: "other", ID: "container"}); err != nil {
+		t.Fatalf("Unexpected error '%v'", err)
+	}
+	if !c.ran("mount", image) || v.Unmounted {
+		t.Errorf("Adopted block file should be mounted, commands %v", c)
+	}
+
+	for _, name := range []string{"missing", "notes", "dir"} {
+		if _, err := d.Get(&volume.GetRequest{Name: name}); err == nil {
+			t.Errorf("Getting '%v' should return error", name)
+		}
+	}
+}
+
+func TestCloneVolume(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "gluster-block-file-plugin-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	glusterfsvolume.MountInfoPath = filepath.Join(tmpDir, "mountinfo")
+	defer func() { glusterfsvolume.MountInfoPath = "/proc/self/mountinfo" }()
+	if err := ioutil.WriteFile(glusterfsvolume.MountInfoPath, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var c commands
+	failing := ""
+	glusterfsvolume.ExecuteCommand = func(_ context.Context, cmd string, args ...string) ([]byte, error) {
+		c.exec(context.Background(), cmd, args...)
+		if cmd == failing {
+			return []byte("failed"), errors.New("exit status 1")
+		}
+		switch cmd {
+		case "truncate", "cp":
+			return []byte{}, ioutil.WriteFile(args[len(args)-1], nil, 0644)
+		case "blkid":
+			return []byte("xfs\n"), nil
+		}
+		return []byte{}, nil
+	}
+
+	d := newTestDriver(tmpDir)
+	if err := d.Create(&volume.CreateRequest{Name: "source"}); err != nil {
+		t.Fatalf("Unexpected error '%v'", err)
+	}
+	source := d.state.GlusterBlockVolumes["source"]
+	gv := d.state.GlusterVolumes[source.GlusterVolumeId]
+	if err := ioutil.WriteFile(glusterfsvolume.MountInfoPath, []byte(
+		"98 22 0:45 / "+gv.Mountpoint+" rw - fuse.glusterfs server1:/myvol rw\n"+
+			"99 22 7:0 / "+source.Mountpoint+" rw - xfs /dev/loop0 rw\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	image := filepath.Join(gv.Mountpoint, "clone.img")
+	create := &volume.CreateRequest{Name: "clone", Options: map[string]string{"from": "source"}}
+
+	for _, cmd := range []string{"cp", "blkid", "xfs_admin", "mount"} {
+		c, failing = nil, cmd
+		if err := d.Create(create); err == nil {
+			t.Errorf("Cloning should return error when %v fails", cmd)
+		}
+		if _, err := os.Lstat(image); !os.IsNotExist(err) {
+			t.Errorf("Cloned block file left behind when %v fails", cmd)
+		}
+		if !c.ran("fsfreeze", "-u") {
+			t.Errorf("Source left frozen when %v fails, commands %v", cmd, c)
+		}
+		if _, ok := d.state.GlusterBlockVolumes["clone"]; ok {
+			t.Errorf("Clone saved when %v fails", cmd)
+		}
+	}
+
+	c, failing = nil, ""
+	if err := d.Create(create); err != nil {
+		t.Fatalf("Unexpected error '%v'", err)
+	}
+	expected := [][]string{
+		{"fsfreeze", "-f", source.Mountpoint},
+		{"cp", "--reflink=auto", "--sparse=always", source.ImagePath, image},
+		{"blkid", "-o", "value", "-s", "TYPE", image},
+		{"xfs_admin", "-U", "generate", image},
+		{"fsfreeze", "-u", source.Mountpoint},
+	}
+	if len(c) < len(expected) || !reflect.DeepEqual([][]string(c[:len(expected)]), expected) {
+		t.Errorf("Unexpected commands\n %v\n expected\n %v", c, expected)
+	}
+	if v := d.state.GlusterBlockVolumes["clone"]; v == nil || v.ClonedFrom != "source" || v.ImagePath != image {
+		t.Errorf("Unexpected clone %#v", v)
+	}
+}
+
+func TestPurgeTrash(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "gluster-block-file-plugin-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	var c commands
+	glusterfsvolume.ExecuteCommand = func(_ context.Context, cmd string, args ...string) ([]byte, error) {
+		c.exec(context.Background(), cmd, args...)
+		if cmd == "truncate" {
+			return []byte{}, ioutil.WriteFile(args[len(args)-1], nil, 0644)
+		}
+		return []byte{}, nil
+	}
+
+	d := newTestDriver(tmpDir)
+	d.trashRetention = time.Hour
+	if err := d.Create(&volume.CreateRequest{Name: "trashed", Options: map[string]string{"on-remove": "trash"}}); err != nil {
+		t.Fatalf("Unexpected error '%v'", err)
+	}
+	gvId := d.state.GlusterBlockVolumes["trashed"].GlusterVolumeId
+	trash := filepath.Join(d.state.GlusterVolumes[gvId].Mountpoint, glusterfsvolume.TrashDir)
+	if err := d.Remove(&volume.RemoveRequest{Name: "trashed"}); err != nil {
+		t.Fatalf("Unexpected error '%v'", err)
+	}
+	if trashed, _ := ioutil.ReadDir(trash); len(trashed) != 1 {
+		t.Errorf("Unexpected trash %v", trashed)
+	}
+	if _, ok := d.state.Trashes[gvId]; !ok || len(d.state.GlusterVolumes) != 0 {
+		t.Errorf("Unexpected trashes %v, gluster volumes %v", d.state.Trashes, d.state.GlusterVolumes)
+	}
+
+	// the unused gluster volume is mounted again to purge its trash.
+	c = nil
+	d.trashRetention = time.Nanosecond
+	d.purgeTrash(context.Background())
+	if len(c) == 0 || c[0][0] != "mount" {
+		t.Errorf("Gluster volume not mounted to purge the trash, commands %v", c)
+	}
+	if trashed, _ := ioutil.ReadDir(trash); len(trashed) != 0 {
+		t.Errorf("Trash not purged %v", trashed)
+	}
+	if len(d.state.Trashes) != 0 || len(d.state.GlusterVolumes) != 0 {
+		t.Errorf("Unexpected trashes %v, gluster volumes %v", d.state.Trashes, d.state.GlusterVolumes)
+	}
+}
+
+func TestGlusterStoreHosts(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "gluster-block-file-plugin-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	defer func(name string) { hostname = name }(hostname)
+
+	var c commands
+	glusterfsvolume.ExecuteCommand = c.exec
+
+	newHost := func(name string) *Driver {
+		hostname = name
+		store, err := newStateStore("gluster", tmpDir, glusterfsvolume.Config{Servers: "server1", VolumeName: "myvol"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		d := newTestDriver(tmpDir)
+		d.store = store
+		return d
+	}
+	hostA, hostB := newHost("host-a"), newHost("host-b")
+
+	if err := hostA.Create(&volume.CreateRequest{Name: "test"}); err != nil {
+		t.Fatalf("Unexpected error '%v'", err)
+	}
+	image := hostA.state.GlusterBlockVolumes["test"].ImagePath
+
+	// block files are local, the other host does not load them.
+	c = nil
+	if err := hostB.LoadState(); err != nil {
+		t.Fatalf("Unexpected error '%v'", err)
+	}
+	hostB.Reconcile(context.Background())
+	if _, ok := hostB.state.GlusterBlockVolumes["test"]; ok || c.ran("mount", image) {
+		t.Errorf("Block file of another host should not be loaded nor mounted, commands %v", c)
+	}
+
+	restarted := newHost("host-a")
+	if err := restarted.LoadState(); err != nil {
+		t.Fatalf("Unexpected error '%v'", err)
+	}
+	if v, ok := restarted.state.GlusterBlockVolumes["test"]; !ok || v.ImagePath != image {
+		t.Errorf("Block file of this host should be loaded, got %#v", v)
+	}
+}
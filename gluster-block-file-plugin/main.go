@@ -1,11 +1,12 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/docker/go-plugins-helpers/volume"
 	"github.com/sirupsen/logrus"
@@ -15,6 +16,13 @@ import (
 
 const socketAddress = "/run/docker/plugins/glusterblockfile.sock"
 
+const defaultMonitorInterval = 30 * time.Second
+
+const defaultTrashRetention = 7 * 24 * time.Hour
+
+// trashPurgeInterval is the delay between two purges of the trashes.
+const trashPurgeInterval = time.Hour
+
 func NewDriver(root string) (*Driver, error) {
 	logrus.WithField("method", "new glusterfs driver").Debug(root)
 
@@ -29,13 +37,7 @@ func NewDriver(root string) (*Driver, error) {
 		if len(kv) == 1 {
 			kv = append(kv, "")
 		}
-		switch kv[0] {
-		default:
-			if err := glusterfsvolume.CheckOption(kv[0], kv[1]); err != nil {
-				return nil, err
-			}
-			options[kv[0]] = kv[1]
-		}
+		options[kv[0]] = kv[1]
 	}
 
 	loglevel := os.Getenv("LOGLEVEL")
@@ -67,6 +69,58 @@ func NewDriver(root string) (*Driver, error) {
 	_, dedicatedMounts := options["dedicated-mount"]
 	delete(options, "dedicated-mount")
 
+	_, resolveServers := options["resolve-servers"]
+	delete(options, "resolve-servers")
+
+	stateStore, _ := options["state-store"]
+	delete(options, "state-store")
+
+	monitorInterval := defaultMonitorInterval
+	if interval, ok := options["monitor-interval"]; ok {
+		var err error
+		if monitorInterval, err = time.ParseDuration(interval); err != nil {
+			return nil, fmt.Errorf("invalid 'monitor-interval' option: %v", err)
+		}
+		delete(options, "monitor-interval")
+	}
+
+	removePolicy := glusterfsvolume.RetainData
+	if policy, ok := options["on-remove"]; ok {
+		if err := glusterfsvolume.CheckRemovePolicy(policy); err != nil {
+			return nil, err
+		}
+		removePolicy = policy
+		delete(options, "on-remove")
+	}
+
+	trashRetention := defaultTrashRetention
+	if retention, ok := options["trash-retention"]; ok {
+		var err error
+		if trashRetention, err = time.ParseDuration(retention); err != nil {
+			return nil, fmt.Errorf("invalid 'trash-retention' option: %v", err)
+		}
+		delete(options, "trash-retention")
+	}
+
+	for _, cmd := range []string{"mount", "umount", "mkfs"} {
+		if val, ok := options[cmd+"-timeout"]; ok {
+			timeout, err := time.ParseDuration(val)
+			if err != nil {
+				return nil, fmt.Errorf("invalid '%v-timeout' option: %v", cmd, err)
+			}
+			glusterfsvolume.Timeouts[cmd] = timeout
+			delete(options, cmd+"-timeout")
+		}
+	}
+
+	// a block file filesystem can not be mounted by several hosts at once.
+	switch scope, _ := options["scope"]; scope {
+	case "", "local":
+		delete(options, "scope")
+	default:
+		return nil, fmt.Errorf("scope '%v' not supported, block files volumes are local", scope)
+	}
+
 	filesystem, _ := options["filesystem"]
 	delete(options, "filesystem")
 
@@ -76,15 +130,64 @@ func NewDriver(root string) (*Driver, error) {
 	size, _ := options["default-size"]
 	delete(options, "default-size")
 
+	discover, adopt := false, false
+	if val, ok := options["discover"]; ok {
+		switch val {
+		case "":
+		case "adopt":
+			adopt = true
+		default:
+			return nil, fmt.Errorf("invalid 'discover' option '%v', expected no value or 'adopt'", val)
+		}
+		discover = true
+	}
+	delete(options, "discover")
+	if discover {
+		if volumeName == "" {
+			return nil, errors.New("'discover' option requires 'VOLUME_NAME' to be set")
+		}
+		if filenameFormat != "" {
+			if err := glusterfsvolume.CheckFilenameFormat(filenameFormat); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// remaining options are gluster mount options.
+	for key, val := range options {
+		if err := glusterfsvolume.CheckOption(key, val); err != nil {
+			return nil, err
+		}
+	}
+
+	lockedOptions, err := glusterfsvolume.ParseLockedOptions(os.Getenv("LOCKED_OPTIONS"))
+	if err != nil {
+		return nil, err
+	}
+
+	glusterConfig := glusterfsvolume.Config{
+		Servers:        servers,
+		VolumeName:     volumeName,
+		DedicatedMount: dedicatedMounts,
+		ResolveServers: resolveServers,
+		Options:        options,
+		LockedOptions:  lockedOptions,
+	}
+
+	store, err := newStateStore(stateStore, root, glusterConfig)
+	if err != nil {
+		return nil, err
+	}
+
 	return &Driver{
-		root:      root,
-		statePath: filepath.Join(root, "gluster-block-file-state.json"),
-		glusterConfig: glusterfsvolume.Config{
-			Servers:        servers,
-			VolumeName:     volumeName,
-			DedicatedMount: dedicatedMounts,
-			Options:        options,
-		},
+		root:            root,
+		store:           store,
+		monitorInterval: monitorInterval,
+		removePolicy:    removePolicy,
+		trashRetention:  trashRetention,
+		discover:        discover,
+		adopt:           adopt,
+		glusterConfig:   glusterConfig,
 		blockFileConfig: BlockFileConfig{
 			filenameFormat: filenameFormat,
 			filesystem:     filesystem,
@@ -97,16 +200,35 @@ func NewDriver(root string) (*Driver, error) {
 	}, nil
 }
 
+// newStateStore returns the state store of kind. Block files are local, so
+// each host keeps its own records in the gluster store.
+func newStateStore(kind string, root string, config glusterfsvolume.Config) (glusterfsvolume.StateStore, error) {
+	if kind == "gluster" && hostname == "" {
+		return nil, errors.New("'state-store=gluster' option requires the host name")
+	}
+	file := newStateFile(filepath.Join(root, "gluster-block-file-state.json"))
+	return glusterfsvolume.NewStateStore(kind, file, filepath.Join("gluster-block-file-plugin", hostname), root, config)
+}
+
 func main() {
 	d, err := NewDriver("/mnt")
 	if err != nil {
 		logrus.Fatal(err)
 	}
+	if err := d.LoadState(); err != nil {
+		logrus.Fatal(err)
+	}
+	ctx := glusterfsvolume.StopContext()
+	d.ctx = ctx
+	d.Reconcile(ctx)
+	if d.monitorInterval > 0 {
+		go glusterfsvolume.Repeat(ctx, d.monitorInterval, d.checkMounts)
+	}
+	if d.trashRetention > 0 {
+		go glusterfsvolume.Repeat(ctx, trashPurgeInterval, d.purgeTrash)
+	}
+
 	h := volume.NewHandler(d)
 	logrus.Infof("listening on %s", socketAddress)
 	logrus.Error(h.ServeUnix(socketAddress, 0))
 }
-
-func executeCommand(cmd string, args ...string) ([]byte, error) {
-	return exec.Command(cmd, args...).CombinedOutput()
-}
@@ -125,3 +125,42 @@ func TestOPTIONdedicatedMount(t *testing.T) {
 			"Dedicated mounts was not activated by 'dedicated-mounts' option")
 	}
 }
+
+func TestOPTIONdiscover(t *testing.T) {
+	root := "/myroot"
+	os.Setenv("VOLUME_NAME", "")
+	os.Setenv("OPTIONS", "discover")
+	defer os.Setenv("OPTIONS", "")
+	if _, err := NewDriver(root); err == nil {
+		t.Error("Discovery without VOLUME_NAME should return error")
+	}
+
+	os.Setenv("VOLUME_NAME", "myvol")
+	defer os.Setenv("VOLUME_NAME", "")
+	os.Setenv("OPTIONS", "discover filename-format=%s-%s.img")
+	if _, err := NewDriver(root); err == nil {
+		t.Error("Discovery with an unmatchable filename format should return error")
+	}
+
+	os.Setenv("OPTIONS", "discover filename-format=vol-%s.img")
+	d, err := NewDriver(root)
+	if err != nil {
+		t.Fatalf("Unexpected error '%v'", err)
+	}
+	if !d.discover || d.adopt {
+		t.Error("Discovery was not enabled by 'discover' option")
+	}
+
+	os.Setenv("OPTIONS", "discover=adopt")
+	if d, err = NewDriver(root); err != nil {
+		t.Fatalf("Unexpected error '%v'", err)
+	}
+	if !d.discover || !d.adopt {
+		t.Error("Adoption was not enabled by 'discover=adopt' option")
+	}
+
+	os.Setenv("OPTIONS", "discover=all")
+	if _, err := NewDriver(root); err == nil {
+		t.Error("Unknown 'discover' value should return error")
+	}
+}
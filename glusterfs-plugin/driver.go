@@ -1,13 +1,15 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
 	"errors"
 	"fmt"
-	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/docker/go-plugins-helpers/volume"
 	"github.com/sirupsen/logrus"
@@ -18,59 +20,281 @@ import (
 type DockerVolume struct {
 	glusterfsvolume.MountedVolume
 	GlusterVolumeId string
+	// BindSource is the directory bind mounted on Mountpoint with BindFlags,
+	// empty when containers use the gluster mount directly.
+	BindSource string   `json:",omitempty"`
+	BindFlags  []string `json:",omitempty"`
+	// DirAttrs are the attributes the subdir was created with.
+	DirAttrs *glusterfsvolume.DirAttrs `json:",omitempty"`
+	// Size is the gluster quota of the subdir.
+	Size string `json:",omitempty"`
+	// Provisioned is set when the plugin created the gluster volume.
+	Provisioned bool `json:",omitempty"`
+	// OnRemove is the policy applied to the subdir on removal, the plugin
+	// one if empty.
+	OnRemove string `json:",omitempty"`
+	// ClonedFrom is the volume the subdir was copied from.
+	ClonedFrom string `json:",omitempty"`
+	// InitFrom is the template the subdir was initialized from.
+	InitFrom string `json:",omitempty"`
+	// Discovered is set when the volume was adopted from a subdir found on
+	// the gluster volume.
+	Discovered bool `json:",omitempty"`
+	// MountIds maps the mount IDs of containers using the volume to the
+	// host they run on.
+	MountIds map[string]string
+}
+
+var hostname, _ = os.Hostname()
+
+// stateVersion is the version of the persisted State, bump it and register a
+// migration in stateMigrations when changing the persisted fields.
+const stateVersion = 2
+
+var stateMigrations = map[int]glusterfsvolume.Migration{
+	// version 1 stored mount IDs as a set, they are all from this host.
+	1: func(state map[string]interface{}) error {
+		volumes, _ := state["DockerVolumes"].(map[string]interface{})
+		for _, v := range volumes {
+			volume, ok := v.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			mountIds, _ := volume["MountIds"].(map[string]interface{})
+			for id := range mountIds {
+				mountIds[id] = hostname
+			}
+		}
+		return nil
+	},
 }
 
 type State struct {
+	Version int
+
 	DockerVolumes  map[string]*DockerVolume
 	GlusterVolumes glusterfsvolume.State
+	// Trashes are the gluster volumes with trashed data.
+	Trashes glusterfsvolume.Trashes `json:",omitempty"`
 }
 
-func (s *State) deleteUnused(gvId string) error {
+// isReferenced tells whether a docker volume is stored on the gluster volume.
+func (s *State) isReferenced(gvId string) bool {
 	for _, v := range s.DockerVolumes {
 		if v.GlusterVolumeId == gvId {
-			return nil
+			return true
 		}
 	}
+	return false
+}
 
-	gv := s.GlusterVolumes[gvId]
-	if err := gv.Unmount(); err != nil {
-		return err
+// isVolumeNameUsed tells whether a docker volume is stored on a gluster
+// volume named volumeName.
+func (s *State) isVolumeNameUsed(volumeName string) bool {
+	for _, v := range s.DockerVolumes {
+		if gv, ok := s.GlusterVolumes[v.GlusterVolumeId]; ok && gv.VolumeName == volumeName {
+			return true
+		}
 	}
-	if err := gv.DeleteMountpoint(); err != nil {
-		logrus.Warnf("Error deleting block file mount point: %s", err)
+	return false
+}
+
+// isUsed tells whether a container of this host uses the gluster volume.
+func (s *State) isUsed(gvId string) bool {
+	for _, v := range s.DockerVolumes {
+		if v.GlusterVolumeId == gvId && v.isMountedOn(hostname) {
+			return true
+		}
 	}
+	return false
+}
 
-	delete(s.GlusterVolumes, gvId)
+// dataPath returns the directory of the volume in the gluster mount.
+func (v *DockerVolume) dataPath() string {
+	if v.BindSource != "" {
+		return v.BindSource
+	}
+	return v.Mountpoint
+}
 
-	return nil
+func (v *DockerVolume) isMountedOn(host string) bool {
+	for _, h := range v.MountIds {
+		if h == host {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *State) volumeStatus(v *DockerVolume) map[string]interface{} {
+	gv, ok := s.GlusterVolumes[v.GlusterVolumeId]
+	if !ok {
+		return map[string]interface{}{"degraded": "gluster volume missing from state"}
+	}
+	status := gv.Status()
+	if status == nil {
+		status = map[string]interface{}{}
+	}
+	if len(v.BindFlags) != 0 {
+		status["bind-flags"] = strings.Join(v.BindFlags, ",")
+	}
+	if v.DirAttrs != nil {
+		status["dir-attrs"] = v.DirAttrs.String()
+	}
+	if v.ClonedFrom != "" {
+		status["cloned-from"] = v.ClonedFrom
+	}
+	if v.InitFrom != "" {
+		status["init-from"] = v.InitFrom
+	}
+	if v.Discovered {
+		status["origin"] = "discovered"
+	}
+	if len(status) == 0 {
+		return nil
+	}
+	return status
+}
+
+func (s *State) unmountUnused(ctx context.Context, gvId string) error {
+	if s.isUsed(gvId) {
+		return nil
+	}
+
+	gv, ok := s.GlusterVolumes[gvId]
+	if !ok {
+		return nil
+	}
+	return gv.Unmount(ctx)
 }
 
 type Driver struct {
-	sync.Mutex
+	// mu protects state and the uses in progress of mounts, it is only held
+	// while reading or updating them, never while mounting. Locks are taken
+	// in this order: volumeLocks, the mount locks, mu.
+	mu sync.Mutex
+	// volumeLocks serializes the operations on a docker volume.
+	volumeLocks glusterfsvolume.KeyedRWMutex
+	// mounts shares the gluster mounts between docker volumes.
+	mounts glusterfsvolume.Mounts
+	// quotas caches the quota usages shown by Get.
+	quotas glusterfsvolume.QuotaCache
 
-	root      string
-	statePath string
+	root  string
+	store glusterfsvolume.StateStore
+	// ctx is done when the plugin stops, killing the commands of requests in
+	// progress. Background if nil.
+	ctx context.Context
+	// globalScope makes the volumes cluster-wide, the state is then reloaded
+	// from the shared store before each operation.
+	globalScope bool
+	// monitorInterval is the delay between two checks of the mounts in use,
+	// 0 disables the monitor.
+	monitorInterval time.Duration
 
 	glusterConfig glusterfsvolume.Config
-	state         State
+	// provisioning creates the missing gluster volumes, nil if disabled.
+	provisioning *glusterfsvolume.Provisioning
+	// removePolicy is applied to the subdirs of removed volumes without
+	// their own policy, retain if empty. trashRetention is how long trashed
+	// data is kept, 0 keeps it forever.
+	removePolicy   string
+	trashRetention time.Duration
+	// discover lists the subdirs of the plugin gluster volume as volumes.
+	// They are adopted when first inspected if adopt is set, otherwise they
+	// are left to their owner.
+	discover bool
+	adopt    bool
+	// discovery caches the subdirs listed.
+	discovery glusterfsvolume.Discovery
+	state     State
+}
+
+// mountState gives d.mounts access to the state of the driver.
+type mountState struct{ d *Driver }
+
+func (s mountState) Lock()   { s.d.mu.Lock() }
+func (s mountState) Unlock() { s.d.mu.Unlock() }
+
+func (s mountState) GlusterVolumes() glusterfsvolume.State {
+	return s.d.state.GlusterVolumes
+}
+
+// Used tells whether a container of this host uses the gluster volume.
+func (s mountState) Used(gvId string) bool {
+	return s.d.state.isUsed(gvId)
+}
+
+// Forget checks the references against the shared state in global scope, as
+// another host may have stored a docker volume on the gluster volume.
+func (s mountState) Forget(gvId string) (bool, error) {
+	forgotten := false
+	err := s.d.updateState(func(state *State) error {
+		if state.isReferenced(gvId) {
+			return nil
+		}
+		delete(state.GlusterVolumes, gvId)
+		forgotten = true
+		return nil
+	})
+	return forgotten, err
+}
+
+// requestContext returns the context of the requests, done when the plugin
+// stops.
+func (d *Driver) requestContext() context.Context {
+	if d.ctx == nil {
+		return context.Background()
+	}
+	return d.ctx
 }
 
 func (d *Driver) Capabilities() *volume.CapabilitiesResponse {
 	logrus.WithField("method", "capabilities").Debugf("")
 
-	return &volume.CapabilitiesResponse{Capabilities: volume.Capability{Scope: "local"}}
+	scope := "local"
+	if d.globalScope {
+		scope = "global"
+	}
+	return &volume.CapabilitiesResponse{Capabilities: volume.Capability{Scope: scope}}
 }
 
 func (d *Driver) Create(r *volume.CreateRequest) error {
 	logrus.WithField("method", "create").Debugf("%#v", r)
+	ctx := d.requestContext()
 
-	d.Lock()
-	defer d.Unlock()
+	// the volume cloned is read locked until the copy is done.
+	from := r.Options["from"]
+	if from == r.Name {
+		return fmt.Errorf("volume %s can not be cloned from itself", r.Name)
+	}
+	unlock := d.volumeLocks.LockWithRLock(r.Name, from)
+	defer unlock()
+
+	if err := d.refreshState(); err != nil {
+		return err
+	}
+	d.mu.Lock()
+	_, exists := d.state.DockerVolumes[r.Name]
+	d.mu.Unlock()
+	if exists && d.globalScope {
+		logrus.WithField("method", "create").Debugf("volume %s already created", r.Name)
+		return nil
+	}
+
+	return d.createVolume(ctx, r.Name, r.Options, volumeSetup{})
+}
+
+// createVolume creates the docker volume name with options, the caller must
+// hold its volume lock and the read lock of the volume of the 'from' option.
+func (d *Driver) createVolume(ctx context.Context, name string, options map[string]string, setup volumeSetup) error {
 	conf := d.glusterConfig.Copy()
 
 	const optionSetError = "'%v' option already set by driver, can not override."
 
-	for key, val := range r.Options {
+	from := ""
+
+	for key, val := range options {
 		switch key {
 		case "servers":
 			if conf.Servers != "" {
@@ -84,86 +308,518 @@ func (d *Driver) Create(r *volume.CreateRequest) error {
 			conf.VolumeName = val
 		case "dedicated-mount":
 			conf.DedicatedMount = true
-		default:
-			if err := glusterfsvolume.CheckOption(key, val); err != nil {
+		case "mount-group":
+			conf.MountGroup = val
+		case "native-subdir":
+			conf.NativeSubdir = true
+		case "from":
+			from = val
+		case "init-from":
+			if err := glusterfsvolume.CheckInitFrom(val); err != nil {
 				return err
 			}
-			if len(d.glusterConfig.Options) != 0 {
-				return errors.New("Gluster options already set by driver, can not override.")
+			setup.initFrom = val
+		case "on-remove":
+			if err := glusterfsvolume.CheckRemovePolicy(val); err != nil {
+				return err
+			}
+			setup.onRemove = val
+		case "size":
+			size, err := glusterfsvolume.ParseQuotaSize(val)
+			if err != nil {
+				return err
+			}
+			setup.size = size
+		default:
+			if glusterfsvolume.BindFlags[key] && val == "" {
+				setup.bindFlags = append(setup.bindFlags, key)
+				continue
+			}
+			if glusterfsvolume.DirAttrOptions[key] {
+				if err := setup.attrs.Set(key, val); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := conf.Override(key, val); err != nil {
+				return err
 			}
-			conf.Options[key] = val
 		}
 	}
 
-	subdirMount := ""
 	if conf.VolumeName == "" {
-		conf.VolumeName = r.Name
+		conf.VolumeName = name
 	} else {
-		subdirMount = r.Name
+		setup.subdir = name
 	}
 
-	id, err := d.state.GlusterVolumes.GetOrCreateVolume(conf, d.root)
-	if err != nil {
-		return err
+	if setup.subdir == "" && setup.initFrom != "" {
+		return errors.New("'init-from' option requires 'volume-name' to be set")
+	}
+	if from != "" {
+		if setup.subdir == "" {
+			return errors.New("'from' option requires 'volume-name' to be set")
+		}
+		if setup.initFrom != "" {
+			return errors.New("'from' and 'init-from' options are exclusive")
+		}
+		source, err := d.cloneSource(from)
+		if err != nil {
+			return err
+		}
+		defer d.releaseCloneSource(ctx, source)
+		setup.from = source
 	}
 
-	defer d.saveState()
-	defer d.state.deleteUnused(id)
+	var parentConf glusterfsvolume.Config
+	if conf.NativeSubdir && setup.subdir != "" {
+		parentConf = conf.Copy()
+		// discovered subdirs were just found on the volume.
+		if !setup.discovered {
+			if err := d.createNativeSubdir(ctx, name, parentConf, &setup); err != nil {
+				return err
+			}
+		}
+		conf.Subdir = setup.subdir
+		setup.subdir = ""
+	} else if setup.subdir == "" && !setup.attrs.IsZero() {
+		return errors.New("'uid', 'gid', 'mode' and 'default-acl' options require 'volume-name' to be set")
+	} else if setup.subdir == "" && setup.size != "" {
+		return errors.New("'size' option requires 'volume-name' to be set")
+	} else if setup.subdir == "" && setup.onRemove != "" {
+		return errors.New("'on-remove' option requires 'volume-name' to be set")
+	}
 
-	gv := d.state.GlusterVolumes[id]
+	if setup.subdir == "" {
+		// the volume has its own gluster mount, flags are mount options.
+		for _, flag := range setup.bindFlags {
+			if err := conf.Override(flag, ""); err != nil {
+				return err
+			}
+		}
+		setup.bindFlags = nil
+	}
+	sort.Strings(setup.bindFlags)
+
+	if d.provisioning != nil && setup.subdir == "" && conf.Subdir == "" {
+		provisioned, err := d.provisionVolume(ctx, conf)
+		if err != nil {
+			return err
+		}
+		setup.provisioned = provisioned
+	}
+
+	err := d.mounts.Use(ctx, mountState{d}, conf, d.root, func(id string, gv *glusterfsvolume.GlusterfsVolume) error {
+		return d.addVolume(ctx, name, id, gv, setup)
+	})
+	if err != nil && conf.Subdir != "" && setup.created {
+		// so that the creation can be retried, the natively mounted subdir
+		// being removed through the whole volume.
+		if err := d.mounts.Use(ctx, mountState{d}, parentConf, d.root, func(id string, gv *glusterfsvolume.GlusterfsVolume) error {
+			return os.RemoveAll(filepath.Join(gv.Mountpoint, conf.Subdir))
+		}); err != nil {
+			logrus.WithField("volume", name).Warnf("Error removing failed clone: %s", err)
+		}
+	}
+	if err != nil && setup.provisioned {
+		gv := glusterfsvolume.GlusterfsVolume{Servers: conf.Servers, VolumeName: conf.VolumeName}
+		if err := gv.Deprovision(ctx, &glusterfsvolume.Provisioning{OnRemove: "delete"}); err != nil {
+			logrus.WithField("volume", name).Warnf("Error deleting provisioned Gluster Volume: %s", err)
+		}
+	}
+	return err
+}
 
-	if err := gv.Mount(); err != nil {
+// createNativeSubdir creates and initializes the subdir of setup through a
+// mount of the whole gluster volume of parentConf. The subdir may exist
+// already, with access to the parent volume denied by auth.allow, so only
+// initialization errors are returned.
+func (d *Driver) createNativeSubdir(ctx context.Context, name string, parentConf glusterfsvolume.Config, setup *volumeSetup) error {
+	var initErr error
+	if err := d.mounts.Use(ctx, mountState{d}, parentConf, d.root, func(id string, gv *glusterfsvolume.GlusterfsVolume) error {
+		subdir := glusterfsvolume.MountedVolume{Mountpoint: filepath.Join(gv.Mountpoint, setup.subdir)}
+		created, err := subdir.CreateDir(ctx, setup.attrs)
+		setup.created = created
+		if err == nil && created && setup.initFrom != "" {
+			// the template is out of reach of the subdir mount.
+			initErr = d.initSubdir(ctx, gv.Mountpoint, subdir.Mountpoint, setup)
+		}
 		return err
+	}); err != nil {
+		logrus.WithField("volume", name).Warnf(
+			"Could not create subdir through volume mount, expecting it to exist: %s", err)
+	}
+	return initErr
+}
+
+// provisionVolume creates the gluster volume of conf if it does not exist,
+// and returns whether it did.
+func (d *Driver) provisionVolume(ctx context.Context, conf glusterfsvolume.Config) (bool, error) {
+	if conf.Servers == "" {
+		return false, errors.New("'servers' option required")
 	}
+	gv := glusterfsvolume.GlusterfsVolume{Servers: conf.Servers, VolumeName: conf.VolumeName}
+
+	exists, err := gv.Exists(ctx)
+	if err != nil {
+		return false, fmt.Errorf("Error checking Gluster Volume: %w", err)
+	}
+	if exists {
+		return false, nil
+	}
+
+	if err := gv.Provision(ctx, d.provisioning); err != nil {
+		return false, fmt.Errorf("Error provisioning Gluster Volume: %w", err)
+	}
+	logrus.WithField("volume", conf.VolumeName).Info("Gluster volume provisioned")
+	return true, nil
+}
+
+// volumeSetup describes a docker volume being created.
+type volumeSetup struct {
+	// subdir is the directory of the volume in the gluster mount, empty
+	// when the volume is the whole mount.
+	subdir    string
+	bindFlags []string
+	attrs     glusterfsvolume.DirAttrs
+	// size is the quota to set on the subdir.
+	size string
+	// provisioned is set when the gluster volume was created for the volume.
+	provisioned bool
+	onRemove    string
+	// from is the volume the subdir is copied from, if any.
+	from *cloneSource
+	// initFrom is the template the subdir is initialized from, seeded is
+	// set once done.
+	initFrom string
+	seeded   bool
+	// created is set once the plugin created the subdir.
+	created bool
+	// discovered is set when adopting an existing subdir, which is then
+	// never created.
+	discovered bool
+}
+
+// cloneSource is the subdir of a volume being cloned, its gluster volume is
+// kept meanwhile.
+type cloneSource struct {
+	name string
+	gvId string
+	gv   *glusterfsvolume.GlusterfsVolume
+	// path is the directory copied, in the gluster mount.
+	path string
+}
+
+// cloneSource finds the subdir of the volume name, and keeps its gluster
+// volume until releaseCloneSource.
+func (d *Driver) cloneSource(name string) (*cloneSource, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	v, ok := d.state.DockerVolumes[name]
+	if !ok {
+		return nil, fmt.Errorf("volume %s not found", name)
+	}
+	gv, ok := d.state.GlusterVolumes[v.GlusterVolumeId]
+	if !ok {
+		return nil, fmt.Errorf("Gluster Volume %s not found", v.GlusterVolumeId)
+	}
+	if gv.Subdir == "" && v.dataPath() == gv.Mountpoint {
+		return nil, fmt.Errorf("volume %s is a whole gluster volume, only subdir volumes can be cloned", name)
+	}
+
+	d.mounts.AddPending(v.GlusterVolumeId, 1)
+	return &cloneSource{name: name, gvId: v.GlusterVolumeId, gv: gv, path: v.dataPath()}, nil
+}
+
+func (d *Driver) releaseCloneSource(ctx context.Context, source *cloneSource) {
+	d.mu.Lock()
+	d.mounts.AddPending(source.gvId, -1)
+	d.mu.Unlock()
+
+	if err := d.mounts.Release(ctx, mountState{d}, source.gvId); err != nil {
+		logrus.WithField("volume", source.gvId).Warnf("Error releasing unused mount: %s", err)
+	}
+}
+
+// initSubdir populates the subdir dir just created with the template of
+// setup, stored on the gluster volume mounted on root.
+func (d *Driver) initSubdir(ctx context.Context, root, dir string, setup *volumeSetup) error {
+	if err := glusterfsvolume.InitDir(ctx, root, setup.initFrom, dir); err != nil {
+		// so that the initialization can be retried.
+		os.RemoveAll(dir)
+		return fmt.Errorf("Error initializing volume: %w", err)
+	}
+	setup.seeded = true
+	return nil
+}
 
+// copyFrom copies the subdir of source into dir, on the mounted gluster
+// volume gvId.
+func (d *Driver) copyFrom(ctx context.Context, source *cloneSource, gvId, dir string) error {
+	if source.gvId != gvId {
+		unlock := d.mounts.RLock(source.gvId)
+		defer unlock()
+
+		if err := d.mounts.Mount(ctx, mountState{d}, source.gvId, source.gv); err != nil {
+			return fmt.Errorf("Error mounting Gluster Volume: %w", err)
+		}
+	}
+	return glusterfsvolume.CopyDir(ctx, source.path, dir)
+}
+
+// addVolume adds the docker volume stored on the mounted gluster volume
+// gvId, in the subdir of setup if set. With bind flags, the subdir gets its
+// own bind mount with these flags.
+func (d *Driver) addVolume(ctx context.Context, name, gvId string, gv *glusterfsvolume.GlusterfsVolume, setup volumeSetup) (err error) {
 	dockerVolume := &DockerVolume{
-		GlusterVolumeId: id,
+		GlusterVolumeId: gvId,
 		MountedVolume:   glusterfsvolume.MountedVolume{Mountpoint: gv.Mountpoint},
 	}
-	if subdirMount != "" {
-		dockerVolume.Mountpoint = filepath.Join(dockerVolume.Mountpoint, subdirMount)
-		if err := dockerVolume.CreateMountpoint(); err != nil {
+	if setup.subdir != "" {
+		dir := filepath.Join(gv.Mountpoint, setup.subdir)
+		defer func() {
+			// so that the creation can be retried, natively mounted subdirs
+			// are removed by createVolume.
+			if err != nil && setup.created {
+				os.RemoveAll(dir)
+			}
+		}()
+
+		dockerVolume.Mountpoint = filepath.Join(dockerVolume.Mountpoint, setup.subdir)
+		if setup.discovered {
+			// the subdir may have been removed since it was discovered.
+			if found, err := glusterfsvolume.IsSubdirDiscovered(gv.Mountpoint, setup.subdir); err != nil {
+				return err
+			} else if !found {
+				return fmt.Errorf("volume %s not found", name)
+			}
+		}
+		created, err := dockerVolume.CreateDir(ctx, setup.attrs)
+		if err != nil {
 			return err
 		}
+		setup.created = created
+	}
+	if setup.created && !setup.attrs.IsZero() {
+		dockerVolume.DirAttrs = &setup.attrs
+	} else if !setup.attrs.IsZero() {
+		logrus.WithField("volume", name).Warnf(
+			"Subdir already exists, not applying %v", setup.attrs.String())
+	}
+	dockerVolume.Provisioned = setup.provisioned
+	dockerVolume.OnRemove = setup.onRemove
+	dockerVolume.Discovered = setup.discovered
+	if setup.size != "" {
+		subdir := setup.subdir
+		if subdir == "" {
+			subdir = gv.Subdir
+		}
+		if err := gv.SetQuota(ctx, subdir, setup.size); err != nil {
+			return fmt.Errorf("Error setting quota: %w", err)
+		}
+		dockerVolume.Size = setup.size
+	}
+	if setup.from != nil {
+		if !setup.created {
+			return fmt.Errorf("subdir of volume %s already exists, can not clone into it", name)
+		}
+		if err := d.copyFrom(ctx, setup.from, gvId, dockerVolume.Mountpoint); err != nil {
+			return fmt.Errorf("Error cloning volume %s: %w", setup.from.name, err)
+		}
+		dockerVolume.ClonedFrom = setup.from.name
+	}
+	if setup.initFrom != "" && setup.subdir != "" && setup.created {
+		if err := d.initSubdir(ctx, gv.Mountpoint, dockerVolume.Mountpoint, &setup); err != nil {
+			return err
+		}
+	}
+	if setup.seeded {
+		dockerVolume.InitFrom = setup.initFrom
+	} else if setup.initFrom != "" {
+		logrus.WithField("volume", name).Warnf(
+			"Subdir already exists, not initializing it from %v", setup.initFrom)
+	}
+	if len(setup.bindFlags) != 0 {
+		dockerVolume.BindSource = dockerVolume.Mountpoint
+		dockerVolume.BindFlags = setup.bindFlags
+		dockerVolume.Mountpoint = filepath.Join(d.root, "_binds", name)
 	}
 
-	d.state.DockerVolumes[r.Name] = dockerVolume
+	d.mu.Lock()
+	defer d.mu.Unlock()
 
+	if err := d.updateState(func(state *State) error {
+		if _, exists := state.DockerVolumes[name]; exists && d.globalScope {
+			logrus.WithField("volume", name).Debug("volume created meanwhile by another host")
+			return nil
+		}
+		// the gluster volume may not be saved yet.
+		if _, ok := state.GlusterVolumes[gvId]; !ok {
+			state.GlusterVolumes[gvId] = gv
+		}
+		state.DockerVolumes[name] = dockerVolume
+		return nil
+	}); err != nil {
+		if d.state.DockerVolumes[name] == dockerVolume {
+			delete(d.state.DockerVolumes, name)
+		}
+		return err
+	}
 	return nil
 }
 
 func (d *Driver) Get(r *volume.GetRequest) (*volume.GetResponse, error) {
 	logrus.WithField("method", "get").Debugf("%#v", r)
+	ctx := d.requestContext()
 
-	d.Lock()
-	defer d.Unlock()
+	if err := d.refreshState(); err != nil {
+		return &volume.GetResponse{}, err
+	}
+	discovered, err := d.discoverVolume(ctx, r.Name)
+	if err != nil {
+		return &volume.GetResponse{}, err
+	}
 
+	d.mu.Lock()
 	v, ok := d.state.DockerVolumes[r.Name]
+	var status map[string]interface{}
+	var gv glusterfsvolume.GlusterfsVolume
+	if ok {
+		status = d.state.volumeStatus(v)
+		if gvp, found := d.state.GlusterVolumes[v.GlusterVolumeId]; found {
+			gv = *gvp
+		}
+	}
+	d.mu.Unlock()
+
+	if !ok && discovered {
+		// listed, but left to its owner.
+		return &volume.GetResponse{Volume: &volume.Volume{
+			Name:   r.Name,
+			Status: map[string]interface{}{"origin": "discovered", "adopted": false},
+		}}, nil
+	}
 	if !ok {
 		return &volume.GetResponse{}, fmt.Errorf("volume %s not found", r.Name)
 	}
 
-	return &volume.GetResponse{Volume: &volume.Volume{Name: r.Name, Mountpoint: v.Mountpoint}}, nil
+	// the quota is read from the servers, out of d.mu and cached. Subdirs
+	// are named after their volume.
+	if v.Size != "" && gv.VolumeName != "" {
+		if status == nil {
+			status = map[string]interface{}{}
+		}
+		status["size"] = v.Size
+		if quota, err := d.quotas.Get(ctx, gv, r.Name); err != nil {
+			status["quota-error"] = err.Error()
+		} else {
+			status["quota-used"] = quota.Used
+			status["quota-available"] = quota.Available
+		}
+	}
+
+	return &volume.GetResponse{Volume: &volume.Volume{
+		Name:       r.Name,
+		Mountpoint: v.Mountpoint,
+		Status:     status,
+	}}, nil
 }
 
 func (d *Driver) List() (*volume.ListResponse, error) {
 	logrus.WithField("method", "list").Debugf("")
+	ctx := d.requestContext()
+
+	if err := d.refreshState(); err != nil {
+		return &volume.ListResponse{}, err
+	}
 
-	d.Lock()
-	defer d.Unlock()
+	var discovered []string
+	if d.discover {
+		var err error
+		if discovered, err = d.discoverVolumes(ctx); err != nil {
+			logrus.WithField("method", "list").Warnf("Error discovering volumes: %s", err)
+		}
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
 
 	var vols []*volume.Volume
 	for name, v := range d.state.DockerVolumes {
 		vols = append(vols, &volume.Volume{Name: name, Mountpoint: v.Mountpoint})
 	}
+	// discovered volumes have no mount point until adopted.
+	for _, name := range discovered {
+		if _, ok := d.state.DockerVolumes[name]; !ok {
+			vols = append(vols, &volume.Volume{Name: name})
+		}
+	}
 	return &volume.ListResponse{Volumes: vols}, nil
 }
 
+// discoverVolumes returns the subdirs found at the root of the plugin
+// gluster volume, which is only mounted once the names cached expire.
+func (d *Driver) discoverVolumes(ctx context.Context) ([]string, error) {
+	return d.discovery.Names(glusterfsvolume.DiscoveryMaxAge, func() ([]string, error) {
+		var names []string
+		err := d.mounts.Use(ctx, mountState{d}, d.glusterConfig.Copy(), d.root, func(id string, gv *glusterfsvolume.GlusterfsVolume) (err error) {
+			names, err = glusterfsvolume.DiscoverSubdirs(gv.Mountpoint)
+			return err
+		})
+		return names, err
+	})
+}
+
+// discoverVolume tells whether the subdir of the unknown volume name is
+// found on the plugin gluster volume when discovery is enabled, and adopts
+// it if adoption is enabled too.
+func (d *Driver) discoverVolume(ctx context.Context, name string) (bool, error) {
+	if !d.discover {
+		return false, nil
+	}
+
+	unlock := d.volumeLocks.Lock(name)
+	defer unlock()
+
+	d.mu.Lock()
+	_, exists := d.state.DockerVolumes[name]
+	d.mu.Unlock()
+	if exists {
+		return false, nil
+	}
+
+	found := false
+	if err := d.mounts.Use(ctx, mountState{d}, d.glusterConfig.Copy(), d.root, func(id string, gv *glusterfsvolume.GlusterfsVolume) (err error) {
+		found, err = glusterfsvolume.IsSubdirDiscovered(gv.Mountpoint, name)
+		return err
+	}); err != nil {
+		return false, fmt.Errorf("Error discovering volume %s: %w", name, err)
+	}
+	if !found || !d.adopt {
+		return found, nil
+	}
+
+	if err := d.createVolume(ctx, name, nil, volumeSetup{discovered: true}); err != nil {
+		return false, fmt.Errorf("Error adopting volume %s: %w", name, err)
+	}
+	logrus.WithField("volume", name).Info("Discovered volume adopted")
+	return true, nil
+}
+
 func (d *Driver) Path(r *volume.PathRequest) (*volume.PathResponse, error) {
 	logrus.WithField("method", "path").Debugf("%#v", r)
+	ctx := d.requestContext()
 
-	d.Lock()
-	defer d.Unlock()
+	if err := d.refreshState(); err != nil {
+		return &volume.PathResponse{}, err
+	}
+	if _, err := d.discoverVolume(ctx, r.Name); err != nil {
+		return &volume.PathResponse{}, err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
 
 	v, ok := d.state.DockerVolumes[r.Name]
 	if !ok {
@@ -175,84 +831,528 @@ func (d *Driver) Path(r *volume.PathRequest) (*volume.PathResponse, error) {
 
 func (d *Driver) Mount(r *volume.MountRequest) (*volume.MountResponse, error) {
 	logrus.WithField("method", "mount").Debugf("%#v", r)
+	ctx := d.requestContext()
+
+	unlock := d.volumeLocks.Lock(r.Name)
+	defer unlock()
+
+	if err := d.refreshState(); err != nil {
+		return &volume.MountResponse{}, err
+	}
 
+	d.mu.Lock()
 	v, ok := d.state.DockerVolumes[r.Name]
+	var gv *glusterfsvolume.GlusterfsVolume
+	var bind DockerVolume
+	if ok {
+		logrus.WithField("method", "mount").Debugf("found volume %#v", v)
+		gv = d.state.GlusterVolumes[v.GlusterVolumeId]
+		bind = *v
+	}
+	d.mu.Unlock()
+
 	if !ok {
 		return &volume.MountResponse{}, fmt.Errorf("volume %s not found", r.Name)
 	}
-	logrus.WithField("method", "mount").Debugf("found volume %#v", v)
+	if gv == nil {
+		return &volume.MountResponse{}, fmt.Errorf("Gluster Volume %s not found", v.GlusterVolumeId)
+	}
+
+	unlockMount := d.mounts.RLock(v.GlusterVolumeId)
+	defer unlockMount()
 
-	if err := d.state.GlusterVolumes[v.GlusterVolumeId].Mount(); err != nil {
-		return &volume.MountResponse{}, fmt.Errorf("Error mounting Gluster Volume: %s", err)
+	if err := d.mounts.Mount(ctx, mountState{d}, v.GlusterVolumeId, gv); err != nil {
+		return &volume.MountResponse{}, fmt.Errorf("Error mounting Gluster Volume: %w", err)
 	}
+	if bind.BindSource != "" {
+		if err := bind.BindMount(ctx, bind.BindSource, bind.BindFlags); err != nil {
+			return &volume.MountResponse{}, fmt.Errorf("Error bind mounting volume: %w", err)
+		}
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	// the state may have been reloaded meanwhile.
+	if err := d.updateState(func(state *State) error {
+		v, ok = state.DockerVolumes[r.Name]
+		if !ok {
+			return fmt.Errorf("volume %s not found", r.Name)
+		}
+		if v.MountIds == nil {
+			v.MountIds = map[string]string{}
+		}
+		v.MountIds[r.ID] = hostname
+		return nil
+	}); err != nil {
+		if ok {
+			delete(v.MountIds, r.ID)
+		}
+		return &volume.MountResponse{}, err
+	}
+
 	return &volume.MountResponse{Mountpoint: v.Mountpoint}, nil
 }
 
 func (d *Driver) Unmount(r *volume.UnmountRequest) error {
 	logrus.WithField("method", "unmount").Debugf("%#v", r)
+	ctx := d.requestContext()
+
+	unlock := d.volumeLocks.Lock(r.Name)
+	defer unlock()
 
-	if _, ok := d.state.DockerVolumes[r.Name]; !ok {
-		return fmt.Errorf("volume %s not found", r.Name)
+	if err := d.refreshState(); err != nil {
+		return err
 	}
 
-	return nil
+	var bind DockerVolume
+	d.mu.Lock()
+	err := d.updateState(func(state *State) error {
+		v, ok := state.DockerVolumes[r.Name]
+		if !ok {
+			return fmt.Errorf("volume %s not found", r.Name)
+		}
+		delete(v.MountIds, r.ID)
+		bind = *v
+		return nil
+	})
+	d.mu.Unlock()
+	if bind.GlusterVolumeId == "" {
+		return err
+	}
+	gvId := bind.GlusterVolumeId
+	unbind := bind.BindSource != "" && !bind.isMountedOn(hostname)
+
+	if unbind {
+		if err := bind.Unmount(ctx); err != nil {
+			return fmt.Errorf("Error unmounting volume: %w", err)
+		}
+	}
+	if err := d.mounts.Release(ctx, mountState{d}, gvId); err != nil {
+		return fmt.Errorf("Error unmounting Gluster Volume: %w", err)
+	}
+	return err
 }
 
 func (d *Driver) Remove(r *volume.RemoveRequest) error {
 	logrus.WithField("method", "remove").Debugf("%#v", r)
+	ctx := d.requestContext()
 
-	d.Lock()
-	defer d.Unlock()
+	unlock := d.volumeLocks.Lock(r.Name)
+	defer unlock()
 
-	v, ok := d.state.DockerVolumes[r.Name]
-	if !ok {
-		return fmt.Errorf("volume %s not found", r.Name)
+	if err := d.refreshState(); err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	var v *DockerVolume
+	removed := false
+	// in global scope, the mount IDs are checked with the shared store
+	// locked, so that no other host mounts the volume meanwhile.
+	if err := d.updateState(func(state *State) error {
+		var ok bool
+		if v, ok = state.DockerVolumes[r.Name]; !ok {
+			return fmt.Errorf("volume %s not found", r.Name)
+		}
+		if d.globalScope && len(v.MountIds) != 0 {
+			return fmt.Errorf("volume %s is in use", r.Name)
+		}
+		delete(state.DockerVolumes, r.Name)
+		removed = true
+		return nil
+	}); err != nil {
+		if removed {
+			d.state.DockerVolumes[r.Name] = v
+		}
+		d.mu.Unlock()
+		return err
 	}
 
 	gvId := v.GlusterVolumeId
-	delete(d.state.DockerVolumes, r.Name)
+	gv, ok := d.state.GlusterVolumes[gvId]
+	var gvCopy glusterfsvolume.GlusterfsVolume
+	if ok {
+		gvCopy = *gv
+	}
+	policy := v.OnRemove
+	if policy == "" {
+		policy = d.removePolicy
+	}
+	// whole gluster volumes keep their data.
+	if policy == "" || !ok || gv.Subdir == "" && v.dataPath() == gv.Mountpoint {
+		policy = glusterfsvolume.RetainData
+	}
+	// data of subdirs bind mounted from the shared mount is removed through
+	// it, before it is released.
+	removeSubdir := policy != glusterfsvolume.RetainData && gv.Subdir == ""
+	if removeSubdir {
+		d.mounts.AddPending(gvId, 1)
+	}
+	d.mu.Unlock()
 
-	if err := d.state.deleteUnused(gvId); err != nil {
+	if err := d.removeVolume(ctx, v, gv, policy, removeSubdir); err != nil {
+		d.restoreVolume(r.Name, v, gv)
 		return err
 	}
+	// its subdir may be listed until then.
+	if policy != glusterfsvolume.RetainData {
+		d.discovery.Reset()
+	}
 
-	d.saveState()
+	if !v.Provisioned || !ok || d.provisioning == nil {
+		return nil
+	}
+	d.mu.Lock()
+	used := d.state.isVolumeNameUsed(gvCopy.VolumeName)
+	d.mu.Unlock()
+	if used {
+		logrus.WithField("volume", r.Name).Warnf(
+			"Gluster Volume %s still used by other volumes, not removing it", gvCopy.VolumeName)
+		return nil
+	}
+	if err := gvCopy.Deprovision(ctx, d.provisioning); err != nil {
+		return fmt.Errorf("Error removing provisioned Gluster Volume: %w", err)
+	}
+	return nil
+}
 
+// removeVolume unmounts the docker volume v, removed from the state, and
+// applies policy to its data. removeSubdir tells whether the data is removed
+// through the mount of gv, on which the caller added a pending use.
+func (d *Driver) removeVolume(ctx context.Context, v *DockerVolume, gv *glusterfsvolume.GlusterfsVolume, policy string, removeSubdir bool) error {
+	gvId := v.GlusterVolumeId
+	if v.BindSource != "" {
+		if err := v.Unmount(ctx); err != nil {
+			if removeSubdir {
+				d.mu.Lock()
+				d.mounts.AddPending(gvId, -1)
+				d.mu.Unlock()
+			}
+			return fmt.Errorf("Error unmounting volume: %w", err)
+		}
+		if err := v.DeleteMountpoint(); err != nil {
+			logrus.Warnf("Error deleting mount point: %s", err)
+		}
+	}
+	if removeSubdir {
+		if err := d.mounts.UsePending(ctx, mountState{d}, gvId, gv, func() error {
+			return d.removeData(ctx, gvId, gv, v.dataPath(), policy)
+		}); err != nil {
+			return fmt.Errorf("Error removing volume data: %w", err)
+		}
+	} else if err := d.mounts.Release(ctx, mountState{d}, gvId); err != nil {
+		return err
+	}
+
+	if gv == nil || policy == glusterfsvolume.RetainData || gv.Subdir == "" {
+		return nil
+	}
+	// natively mounted subdirs are removed through the whole volume.
+	parent := glusterfsvolume.Config{
+		Servers:        gv.Servers,
+		VolumeName:     gv.VolumeName,
+		Options:        gv.Options,
+		ResolveServers: d.glusterConfig.ResolveServers,
+	}.Copy()
+	if err := d.mounts.Use(ctx, mountState{d}, parent, d.root, func(id string, parentGv *glusterfsvolume.GlusterfsVolume) error {
+		return d.removeData(ctx, id, parentGv, filepath.Join(parentGv.Mountpoint, gv.Subdir), policy)
+	}); err != nil {
+		return fmt.Errorf("Error removing volume data: %w", err)
+	}
 	return nil
 }
 
+// restoreVolume puts back the docker volume name, removed from the state
+// before its removal failed, along with its gluster volume gv if forgotten
+// meanwhile, so that the removal can be retried.
+func (d *Driver) restoreVolume(name string, v *DockerVolume, gv *glusterfsvolume.GlusterfsVolume) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := d.updateState(func(state *State) error {
+		if _, exists := state.DockerVolumes[name]; exists {
+			return nil
+		}
+		state.DockerVolumes[name] = v
+		if _, ok := state.GlusterVolumes[v.GlusterVolumeId]; !ok && gv != nil {
+			state.GlusterVolumes[v.GlusterVolumeId] = gv
+		}
+		return nil
+	}); err != nil {
+		logrus.WithField("volume", name).Errorf("Error restoring volume after failed removal: %s", err)
+	}
+}
+
+// removeData applies policy to path, stored on the mounted gluster volume
+// gvId, whose trash is then purged and recorded for later purges.
+func (d *Driver) removeData(ctx context.Context, gvId string, gv *glusterfsvolume.GlusterfsVolume, path, policy string) error {
+	if err := glusterfsvolume.RemoveData(gv.Mountpoint, path, policy); err != nil {
+		return err
+	}
+	if policy != glusterfsvolume.TrashData || d.trashRetention <= 0 {
+		return nil
+	}
+	if _, err := glusterfsvolume.PurgeTrash(gv.Mountpoint, d.trashRetention); err != nil {
+		logrus.WithField("volume", gvId).Warnf("Error purging trash: %s", err)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := d.updateState(func(state *State) error {
+		state.Trashes.Record(gvId, gv)
+		return nil
+	}); err != nil {
+		logrus.WithField("volume", gvId).Warnf("Error recording trash: %s", err)
+	}
+	return nil
+}
+
+// purgeTrash deletes the data trashed for longer than trashRetention on the
+// gluster volumes with trashed data.
+func (d *Driver) purgeTrash(ctx context.Context) {
+	d.mu.Lock()
+	trashes := d.state.Trashes.Copy()
+	d.mu.Unlock()
+
+	purged := d.mounts.PurgeTrashes(ctx, mountState{d}, d.root, trashes, d.glusterConfig.ResolveServers, d.trashRetention)
+	if len(purged) == 0 {
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := d.updateState(func(state *State) error {
+		state.Trashes.Drop(purged)
+		return nil
+	}); err != nil {
+		logrus.WithField("method", "purgeTrash").Warnf("Error recording purged trash: %s", err)
+	}
+}
+
 func (d *Driver) LoadState() error {
-	logrus.WithField("method", "LoadState").Debugf("loading state from '%v'", d.statePath)
+	logrus.WithField("method", "LoadState").Debugf("loading state from '%v'", d.store)
 
-	data, err := ioutil.ReadFile(d.statePath)
-	if err != nil {
-		if os.IsNotExist(err) {
-			logrus.WithField("statePath", d.statePath).Debug("no state found")
-		} else {
+	return d.store.Load(&d.state)
+}
+
+// Reconcile brings the mount table in line with the loaded state. Mounts of
+// containers of this host did not survive the restart, docker mounts the
+// volumes again for the containers it starts: their mount IDs are cleared,
+// and the bind and gluster mounts left over are released.
+func (d *Driver) Reconcile(ctx context.Context) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	cleared := 0
+	if err := d.updateState(func(state *State) error {
+		cleared = 0
+		for _, v := range state.DockerVolumes {
+			for id, host := range v.MountIds {
+				if host == hostname {
+					delete(v.MountIds, id)
+					cleared++
+				}
+			}
+		}
+		return nil
+	}); err != nil {
+		logrus.Errorf("Error clearing the mounts of this host: %s", err)
+	}
+
+	degraded := 0
+	for name, v := range d.state.DockerVolumes {
+		if _, ok := d.state.GlusterVolumes[v.GlusterVolumeId]; !ok {
+			logrus.WithField("volume", name).Errorf(
+				"Gluster Volume %s not found in state", v.GlusterVolumeId)
+			degraded++
+			continue
+		}
+		if v.BindSource == "" {
+			continue
+		}
+		if err := v.Unmount(ctx); err != nil {
+			logrus.WithField("volume", name).Warnf("Error releasing bind mount: %s", err)
+		}
+	}
+
+	for id := range d.state.GlusterVolumes {
+		if err := d.state.unmountUnused(ctx, id); err != nil {
+			logrus.WithField("volume", id).Warnf("Error releasing unused mount: %s", err)
+		}
+	}
+
+	log := logrus.Infof
+	if degraded != 0 {
+		log = logrus.Warnf
+	}
+	log("Restored %d volumes (%d gluster volumes): %d container mounts cleared, %d degraded",
+		len(d.state.DockerVolumes), len(d.state.GlusterVolumes), cleared, degraded)
+}
+
+// checkMounts checks the gluster mounts in use, remounts the stale or
+// missing ones, and then the bind mounts of their volumes.
+func (d *Driver) checkMounts(ctx context.Context) {
+	if err := d.refreshState(); err != nil {
+		logrus.WithField("method", "checkMounts").Error(err)
+		return
+	}
+
+	d.mounts.HealAll(ctx, mountState{d}, d.rebind)
+}
+
+// rebind bind mounts again the volumes used on this host of the gluster
+// volume id, once remounted.
+func (d *Driver) rebind(ctx context.Context, id string, remounted bool) {
+	if !remounted {
+		return
+	}
+
+	d.mu.Lock()
+	binds := map[string]DockerVolume{}
+	for name, v := range d.state.DockerVolumes {
+		if v.GlusterVolumeId == id && v.BindSource != "" && v.isMountedOn(hostname) {
+			binds[name] = *v
+		}
+	}
+	d.mu.Unlock()
+
+	// bind mounts of the previous mount are stale.
+	for name, v := range binds {
+		if v.IsMounted() {
+			if err := v.ForceUnmount(ctx); err != nil {
+				logrus.WithField("volume", name).Errorf("Error unmounting stale bind mount: %s", err)
+				continue
+			}
+		}
+		if err := v.BindMount(ctx, v.BindSource, v.BindFlags); err != nil {
+			logrus.WithField("volume", name).Errorf("Error bind mounting: %s", err)
+		}
+	}
+}
+
+// refreshState reloads the state from the shared store in global scope, so
+// that volumes created or removed from other hosts are seen.
+func (d *Driver) refreshState() error {
+	if !d.globalScope {
+		return nil
+	}
+
+	d.mu.Lock()
+
+	state := State{
+		DockerVolumes:  map[string]*DockerVolume{},
+		GlusterVolumes: glusterfsvolume.State{},
+	}
+	if err := d.store.Load(&state); err != nil {
+		d.mu.Unlock()
+		return fmt.Errorf("Error loading state: %v", err)
+	}
+	removed := d.adoptState(state)
+	d.mu.Unlock()
+
+	for id, gv := range removed {
+		d.releaseRemoved(id, gv)
+	}
+	return nil
+}
+
+// adoptState replaces the state with one loaded from the shared store, and
+// returns the gluster volumes it no longer has, to be released by the caller
+// without d.mu held. The caller must hold d.mu.
+func (d *Driver) adoptState(state State) map[string]*glusterfsvolume.GlusterfsVolume {
+	removed := map[string]*glusterfsvolume.GlusterfsVolume{}
+	for id, gv := range d.state.GlusterVolumes {
+		if newGv, ok := state.GlusterVolumes[id]; ok {
+			newGv.Health = gv.Health
+			continue
+		}
+		if d.mounts.Pending(id) {
+			// being created on this host, not saved yet.
+			state.GlusterVolumes[id] = gv
+			continue
+		}
+		// last volume using it was removed from another host.
+		removed[id] = gv
+	}
+
+	d.state = state
+	return removed
+}
+
+// updateState calls fn to change the state and saves it, the caller must
+// hold d.mu. In global scope, fn changes the state reloaded with the shared
+// store locked until saved, so that concurrent changes of other hosts are
+// not lost; the state of the driver is left as is if fn or saving fails.
+func (d *Driver) updateState(fn func(state *State) error) error {
+	updater, ok := d.store.(glusterfsvolume.StateUpdater)
+	if !d.globalScope || !ok {
+		if err := fn(&d.state); err != nil {
 			return err
 		}
-	} else {
-		if err := json.Unmarshal(data, &d.state); err != nil {
+		return d.saveState()
+	}
+
+	state := State{
+		DockerVolumes:  map[string]*DockerVolume{},
+		GlusterVolumes: glusterfsvolume.State{},
+	}
+	var fnErr error
+	if err := updater.Update(&state, func() error {
+		if fnErr = fn(&state); fnErr != nil {
+			return fnErr
+		}
+		state.Version = stateVersion
+		return nil
+	}); err != nil {
+		if err == fnErr {
 			return err
 		}
+		logrus.WithField("store", d.store).Error(err)
+		return fmt.Errorf("Error saving state: %v", err)
 	}
 
+	// d.mu is held, the mount locks of removed volumes can not be taken.
+	for id, gv := range d.adoptState(state) {
+		go d.releaseRemoved(id, gv)
+	}
 	return nil
 }
 
-func (d *Driver) saveState() {
-	logrus.WithField("method", "saveState").Debugf(
-		"saving state %#v to '%v'", d.state, d.statePath)
-	data, err := json.Marshal(d.state)
-	if err != nil {
-		logrus.WithField("statePath", d.statePath).Error(err)
+// releaseRemoved unmounts a gluster volume removed from the shared state,
+// unless it was added back meanwhile.
+func (d *Driver) releaseRemoved(id string, gv *glusterfsvolume.GlusterfsVolume) {
+	unlock := d.mounts.Lock(id)
+	defer unlock()
+
+	d.mu.Lock()
+	_, ok := d.state.GlusterVolumes[id]
+	d.mu.Unlock()
+	if ok {
 		return
 	}
 
-	if err := ioutil.WriteFile(d.statePath, data, 0644); err != nil {
-		logrus.WithField("savestate", d.statePath).Error(err)
+	if err := gv.Unmount(d.requestContext()); err != nil {
+		logrus.WithField("volume", id).Warnf("Error releasing removed mount: %s", err)
+	} else if err := gv.DeleteMountpoint(); err != nil {
+		logrus.Warnf("Error deleting mount point: %s", err)
 	}
+}
 
+// saveState persists the state, the caller must hold d.mu.
+func (d *Driver) saveState() error {
+	d.state.Version = stateVersion
+	return glusterfsvolume.SaveState(d.store, d.state)
+}
+
+func newStateFile(path string) glusterfsvolume.StateFile {
+	return glusterfsvolume.StateFile{
+		Path:       path,
+		Version:    stateVersion,
+		Migrations: stateMigrations,
+	}
 }
 
 func (d *Driver) GetOptions() map[string]string {
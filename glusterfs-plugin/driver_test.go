@@ -1,11 +1,17 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"reflect"
+	"sort"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/docker/go-plugins-helpers/volume"
 
@@ -86,7 +92,7 @@ type executor struct {
 	args []string
 }
 
-func (e *executor) exec(cmd string, args ...string) ([]byte, error) {
+func (e *executor) exec(_ context.Context, cmd string, args ...string) ([]byte, error) {
 	e.cmd = cmd
 	e.args = args
 
@@ -104,7 +110,8 @@ func TestSubDirMount(t *testing.T) {
 	glusterfsvolume.ExecuteCommand = e.exec
 
 	d := Driver{
-		root: tmpDir,
+		root:  tmpDir,
+		store: newStateFile(filepath.Join(tmpDir, "test-state.json")),
 		glusterConfig: glusterfsvolume.Config{
 			Servers:    "server1,server2",
 			VolumeName: "myvol",
@@ -133,6 +140,44 @@ func TestSubDirMount(t *testing.T) {
 	}
 }
 
+func TestStoppedDriver(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "glusterfs-plugin-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	var commands []string
+	glusterfsvolume.ExecuteCommand = func(ctx context.Context, cmd string, args ...string) ([]byte, error) {
+		commands = append(commands, cmd)
+		return nil, ctx.Err()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	d := Driver{
+		root:  tmpDir,
+		store: newStateFile(filepath.Join(tmpDir, "test-state.json")),
+		ctx:   ctx,
+		glusterConfig: glusterfsvolume.Config{
+			Servers:    "server1,server2",
+			VolumeName: "myvol",
+		},
+		state: State{
+			DockerVolumes:  map[string]*DockerVolume{},
+			GlusterVolumes: map[string]*glusterfsvolume.GlusterfsVolume{},
+		},
+	}
+
+	err = d.Create(&volume.CreateRequest{Name: "test"})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected cancellation error, got '%v'", err)
+	}
+	if len(commands) != 1 {
+		t.Errorf("Mount should not fail over once stopped, got commands %v", commands)
+	}
+}
+
 func TestNoSubDirMount(t *testing.T) {
 	tmpDir, err := ioutil.TempDir("", "glusterfs-plugin-test-")
 	if err != nil {
@@ -141,7 +186,8 @@ func TestNoSubDirMount(t *testing.T) {
 	defer os.RemoveAll(tmpDir)
 
 	d := Driver{
-		root: tmpDir,
+		root:  tmpDir,
+		store: newStateFile(filepath.Join(tmpDir, "test-state.json")),
 		glusterConfig: glusterfsvolume.Config{
 			Servers: "server1,server2",
 		},
@@ -176,8 +222,8 @@ func TestStateSaveAndLoad(t *testing.T) {
 	statePath := filepath.Join(tmpDir, "test-state.json")
 
 	d := Driver{
-		root:      tmpDir,
-		statePath: statePath,
+		root:  tmpDir,
+		store: newStateFile(statePath),
 		glusterConfig: glusterfsvolume.Config{
 			Servers:    "server1,server2",
 			VolumeName: "myvol",
@@ -199,16 +245,1334 @@ func TestStateSaveAndLoad(t *testing.T) {
 
 	// unmount volumes so that states can be compared after load (mount not exported)
 	for _, gv := range d.state.GlusterVolumes {
-		gv.Unmount()
+		gv.Unmount(context.Background())
+		gv.ActiveServer = ""
 	}
 
-	d.saveState()
+	if err := d.saveState(); err != nil {
+		t.Fatalf("Unexpected error saving state '%v'", err)
+	}
 
-	d2 := Driver{statePath: statePath}
-	d2.LoadState()
+	d2 := Driver{store: newStateFile(statePath)}
+	if err := d2.LoadState(); err != nil {
+		t.Fatalf("Unexpected error loading state '%v'", err)
+	}
 
 	if !reflect.DeepEqual(d.state, d2.state) {
 		t.Errorf(
 			"Loaded state\n%#v\n differs from original state\n%#v", d2.state, d.state)
 	}
 }
+
+func TestMountIdsTracking(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "glusterfs-plugin-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	e := executor{}
+	glusterfsvolume.ExecuteCommand = e.exec
+
+	statePath := filepath.Join(tmpDir, "test-state.json")
+
+	d := Driver{
+		root:  tmpDir,
+		store: newStateFile(statePath),
+		glusterConfig: glusterfsvolume.Config{
+			Servers:    "server1,server2",
+			VolumeName: "myvol",
+		},
+		state: State{
+			DockerVolumes:  map[string]*DockerVolume{},
+			GlusterVolumes: map[string]*glusterfsvolume.GlusterfsVolume{},
+		},
+	}
+
+	if err := d.Create(&volume.CreateRequest{Name: "test"}); err != nil {
+		t.Fatalf("Unexpected error '%v'", err)
+	}
+
+	for _, id := range []string{"container1", "container2"} {
+		if _, err := d.Mount(&volume.MountRequest{Name: "test", ID: id}); err != nil {
+			t.Fatalf("Unexpected error '%v'", err)
+		}
+	}
+	if l := len(d.state.DockerVolumes["test"].MountIds); l != 2 {
+		t.Errorf("Expected 2 mount ids, got %v", l)
+	}
+
+	d2 := Driver{store: newStateFile(statePath)}
+	d2.LoadState()
+	if !reflect.DeepEqual(d.state.DockerVolumes["test"].MountIds, d2.state.DockerVolumes["test"].MountIds) {
+		t.Errorf("Mount ids not persisted: %#v", d2.state.DockerVolumes["test"].MountIds)
+	}
+
+	// gluster volume now seen as mounted.
+	gv := d.state.GlusterVolumes[d.state.DockerVolumes["test"].GlusterVolumeId]
+	glusterfsvolume.MountInfoPath = filepath.Join(tmpDir, "mountinfo")
+	defer func() { glusterfsvolume.MountInfoPath = "/proc/self/mountinfo" }()
+	if err := ioutil.WriteFile(glusterfsvolume.MountInfoPath, []byte(
+		"98 22 0:45 / "+gv.Mountpoint+" rw - fuse.glusterfs server1:/myvol rw\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := d.Unmount(&volume.UnmountRequest{Name: "test", ID: "container1"}); err != nil {
+		t.Fatalf("Unexpected error '%v'", err)
+	}
+	if e.cmd == "umount" {
+		t.Error("Gluster volume unmounted while still used")
+	}
+	if !reflect.DeepEqual(d.state.DockerVolumes["test"].MountIds, map[string]string{"container2": hostname}) {
+		t.Errorf("Unexpected mount ids %#v", d.state.DockerVolumes["test"].MountIds)
+	}
+
+	if err := d.Unmount(&volume.UnmountRequest{Name: "test", ID: "container2"}); err != nil {
+		t.Fatalf("Unexpected error '%v'", err)
+	}
+	if l := len(d.state.DockerVolumes["test"].MountIds); l != 0 {
+		t.Errorf("Expected no mount ids, got %v", l)
+	}
+	if e.cmd != "umount" || e.args[0] != gv.Mountpoint {
+		t.Errorf("Unused gluster volume not unmounted: %v %v", e.cmd, e.args)
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "glusterfs-plugin-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// the gluster volume used before the restart is still mounted.
+	usedMountpoint := filepath.Join(tmpDir, "used")
+	if err := os.MkdirAll(usedMountpoint, 0755); err != nil {
+		t.Fatal(err)
+	}
+	glusterfsvolume.MountInfoPath = filepath.Join(tmpDir, "mountinfo")
+	defer func() { glusterfsvolume.MountInfoPath = "/proc/self/mountinfo" }()
+	mountInfo := "98 22 0:45 / " + usedMountpoint + " rw - fuse.glusterfs server1:/used rw\n"
+	if err := ioutil.WriteFile(glusterfsvolume.MountInfoPath, []byte(mountInfo), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	statePath := filepath.Join(tmpDir, "test-state.json")
+	d := &Driver{
+		root:  tmpDir,
+		store: newStateFile(statePath),
+		state: State{
+			DockerVolumes: map[string]*DockerVolume{
+				"used": {
+					GlusterVolumeId: "server1/used",
+					MountIds:        map[string]string{"container": hostname, "remote": "other-host"},
+				},
+				"unused": {GlusterVolumeId: "server1/unused"},
+				"orphan": {GlusterVolumeId: "server1/missing"},
+			},
+			GlusterVolumes: map[string]*glusterfsvolume.GlusterfsVolume{
+				"server1/used": {
+					Servers:       "server1",
+					VolumeName:    "used",
+					MountedVolume: glusterfsvolume.MountedVolume{Mountpoint: usedMountpoint},
+				},
+				"server1/unused": {
+					Servers:       "server1",
+					VolumeName:    "unused",
+					MountedVolume: glusterfsvolume.MountedVolume{Mountpoint: filepath.Join(tmpDir, "unused")},
+				},
+			},
+		},
+	}
+
+	e := executor{}
+	glusterfsvolume.ExecuteCommand = e.exec
+
+	d.Reconcile(context.Background())
+	if e.cmd != "umount" || e.args[0] != usedMountpoint {
+		t.Errorf("Gluster volume used before the restart not released: %v %v", e.cmd, e.args)
+	}
+	expected := map[string]string{"remote": "other-host"}
+	if mountIds := d.state.DockerVolumes["used"].MountIds; !reflect.DeepEqual(mountIds, expected) {
+		t.Errorf("Mount IDs of this host not cleared, got %v", mountIds)
+	}
+	if status := d.state.volumeStatus(d.state.DockerVolumes["orphan"]); status["degraded"] == nil {
+		t.Error("Volume without gluster volume should be degraded")
+	}
+
+	d2 := Driver{store: newStateFile(statePath)}
+	if err := d2.LoadState(); err != nil {
+		t.Fatal(err)
+	}
+	if mountIds := d2.state.DockerVolumes["used"].MountIds; !reflect.DeepEqual(mountIds, expected) {
+		t.Errorf("Cleared mount IDs not saved, got %v", mountIds)
+	}
+}
+
+func TestGlobalScope(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "glusterfs-plugin-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	e := executor{}
+	glusterfsvolume.ExecuteCommand = e.exec
+
+	config := glusterfsvolume.Config{Servers: "server1", VolumeName: "myvol"}
+	newHost := func() *Driver {
+		store, err := newStateStore("gluster", tmpDir, config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &Driver{
+			root:          tmpDir,
+			store:         store,
+			globalScope:   true,
+			glusterConfig: config,
+			state: State{
+				DockerVolumes:  map[string]*DockerVolume{},
+				GlusterVolumes: glusterfsvolume.State{},
+			},
+		}
+	}
+	hostA, hostB := newHost(), newHost()
+
+	if scope := hostA.Capabilities().Capabilities.Scope; scope != "global" {
+		t.Errorf("Unexpected scope '%v'", scope)
+	}
+
+	if err := hostA.Create(&volume.CreateRequest{Name: "test"}); err != nil {
+		t.Fatalf("Unexpected error '%v'", err)
+	}
+
+	r, err := hostB.Get(&volume.GetRequest{Name: "test"})
+	if err != nil {
+		t.Fatalf("Volume created on another host not found: %v", err)
+	}
+	if r.Volume.Mountpoint != filepath.Join(tmpDir, "server1", "myvol", "test") {
+		t.Errorf("Unexpected mount point '%v'", r.Volume.Mountpoint)
+	}
+	if l, _ := hostB.List(); len(l.Volumes) != 1 {
+		t.Errorf("Unexpected volumes %#v", l.Volumes)
+	}
+
+	if _, err := hostB.Mount(&volume.MountRequest{Name: "test", ID: "container"}); err != nil {
+		t.Fatalf("Unexpected error '%v'", err)
+	}
+	if err := hostA.Remove(&volume.RemoveRequest{Name: "test"}); err == nil {
+		t.Error("Removing a volume in use on another host should return error")
+	}
+	if err := hostB.Unmount(&volume.UnmountRequest{Name: "test", ID: "container"}); err != nil {
+		t.Fatalf("Unexpected error '%v'", err)
+	}
+	if err := hostA.Remove(&volume.RemoveRequest{Name: "test"}); err != nil {
+		t.Fatalf("Unexpected error '%v'", err)
+	}
+
+	if _, err := hostB.Get(&volume.GetRequest{Name: "test"}); err == nil {
+		t.Error("Volume removed on another host should not be found")
+	}
+}
+
+func TestGlobalScopeStaleRelease(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "glusterfs-plugin-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	e := executor{}
+	glusterfsvolume.ExecuteCommand = e.exec
+
+	config := glusterfsvolume.Config{Servers: "server1", VolumeName: "myvol"}
+	newHost := func() *Driver {
+		store, err := newStateStore("gluster", tmpDir, config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &Driver{
+			root:          tmpDir,
+			store:         store,
+			globalScope:   true,
+			glusterConfig: config,
+			state: State{
+				DockerVolumes:  map[string]*DockerVolume{},
+				GlusterVolumes: glusterfsvolume.State{},
+			},
+		}
+	}
+	hostA, hostB := newHost(), newHost()
+
+	if err := hostA.Create(&volume.CreateRequest{Name: "a"}); err != nil {
+		t.Fatalf("Unexpected error '%v'", err)
+	}
+	gvId := hostA.state.DockerVolumes["a"].GlusterVolumeId
+	if err := hostB.Create(&volume.CreateRequest{Name: "b"}); err != nil {
+		t.Fatalf("Unexpected error '%v'", err)
+	}
+
+	// host A has not seen volume b yet, and its volume is gone.
+	hostA.mu.Lock()
+	delete(hostA.state.DockerVolumes, "a")
+	hostA.mu.Unlock()
+	if err := hostA.mounts.Release(context.Background(), mountState{hostA}, gvId); err != nil {
+		t.Fatalf("Unexpected error '%v'", err)
+	}
+
+	if err := hostB.refreshState(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := hostB.state.GlusterVolumes[gvId]; !ok {
+		t.Error("Gluster volume of another host volume forgotten")
+	}
+	if _, ok := hostB.state.DockerVolumes["b"]; !ok {
+		t.Error("Volume of another host lost")
+	}
+}
+
+func TestGlobalScopeConcurrentMounts(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "glusterfs-plugin-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// once armed, the first mount of the volume, by host A, waits for host B
+	// to mount it.
+	var armed, mounts int32
+	entered, release := make(chan struct{}), make(chan struct{})
+	glusterfsvolume.ExecuteCommand = func(_ context.Context, cmd string, args ...string) ([]byte, error) {
+		if cmd == "mount" && len(args) > 3 && args[3] == filepath.Join(tmpDir, "server1", "myvol") &&
+			atomic.LoadInt32(&armed) == 1 && atomic.AddInt32(&mounts, 1) == 1 {
+			close(entered)
+			<-release
+		}
+		return []byte{}, nil
+	}
+
+	config := glusterfsvolume.Config{Servers: "server1", VolumeName: "myvol"}
+	newHost := func() *Driver {
+		store, err := newStateStore("gluster", tmpDir, config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &Driver{
+			root:          tmpDir,
+			store:         store,
+			globalScope:   true,
+			glusterConfig: config,
+			state: State{
+				DockerVolumes:  map[string]*DockerVolume{},
+				GlusterVolumes: glusterfsvolume.State{},
+			},
+		}
+	}
+	hostA, hostB := newHost(), newHost()
+
+	if err := hostA.Create(&volume.CreateRequest{Name: "test"}); err != nil {
+		t.Fatalf("Unexpected error '%v'", err)
+	}
+
+	atomic.StoreInt32(&armed, 1)
+	mounted := make(chan error)
+	go func() {
+		_, err := hostA.Mount(&volume.MountRequest{Name: "test", ID: "containerA"})
+		mounted <- err
+	}()
+	select {
+	case <-entered:
+	case err := <-mounted:
+		t.Fatalf("Host A mounted without mounting the gluster volume, got '%v'", err)
+	}
+	// host A loaded the state before host B saves its mount.
+	if _, err := hostB.Mount(&volume.MountRequest{Name: "test", ID: "containerB"}); err != nil {
+		t.Fatalf("Unexpected error '%v'", err)
+	}
+	close(release)
+	if err := <-mounted; err != nil {
+		t.Fatalf("Unexpected error '%v'", err)
+	}
+
+	if err := hostB.refreshState(); err != nil {
+		t.Fatal(err)
+	}
+	mountIds := hostB.state.DockerVolumes["test"].MountIds
+	if _, ok := mountIds["containerA"]; !ok || len(mountIds) != 2 {
+		t.Errorf("Mount lost, got mount IDs %v", mountIds)
+	}
+
+	if err := hostB.Unmount(&volume.UnmountRequest{Name: "test", ID: "containerB"}); err != nil {
+		t.Fatalf("Unexpected error '%v'", err)
+	}
+	if err := hostB.Remove(&volume.RemoveRequest{Name: "test"}); err == nil {
+		t.Error("Removing a volume in use on another host should return error")
+	}
+}
+
+func TestStateMigrationMountIds(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "glusterfs-plugin-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	statePath := filepath.Join(tmpDir, "test-state.json")
+	if err := ioutil.WriteFile(statePath, []byte(
+		`{"Version":1,"DockerVolumes":{"test":{"MountIds":{"container":true}}}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	d := Driver{store: newStateFile(statePath)}
+	if err := d.LoadState(); err != nil {
+		t.Fatalf("Unexpected error loading state '%v'", err)
+	}
+	if !reflect.DeepEqual(d.state.DockerVolumes["test"].MountIds, map[string]string{"container": hostname}) {
+		t.Errorf("Unexpected mount ids %#v", d.state.DockerVolumes["test"].MountIds)
+	}
+}
+
+func TestConcurrentMounts(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "glusterfs-plugin-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	glusterfsvolume.MountInfoPath = filepath.Join(tmpDir, "mountinfo")
+	defer func() { glusterfsvolume.MountInfoPath = "/proc/self/mountinfo" }()
+	if err := ioutil.WriteFile(glusterfsvolume.MountInfoPath, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	mounts := 0
+	started := make(chan struct{})
+	release := make(chan struct{})
+	glusterfsvolume.ExecuteCommand = func(_ context.Context, cmd string, args ...string) ([]byte, error) {
+		if cmd == "mount" {
+			mu.Lock()
+			mounts++
+			if mounts == 1 {
+				close(started)
+			}
+			mu.Unlock()
+			<-release
+			// later mounts find it mounted, whether they waited for this one
+			// or not.
+			line := "98 22 0:45 / " + args[3] + " rw - fuse.glusterfs " + args[2] + " rw\n"
+			return []byte{}, ioutil.WriteFile(glusterfsvolume.MountInfoPath, []byte(line), 0644)
+		}
+		return []byte{}, nil
+	}
+
+	gvId := "server1/myvol"
+	d := Driver{
+		root:  tmpDir,
+		store: newStateFile(filepath.Join(tmpDir, "test-state.json")),
+		state: State{
+			DockerVolumes: map[string]*DockerVolume{
+				"vol1": {GlusterVolumeId: gvId},
+				"vol2": {GlusterVolumeId: gvId},
+			},
+			GlusterVolumes: glusterfsvolume.State{
+				gvId: {
+					Servers:       "server1",
+					VolumeName:    "myvol",
+					MountedVolume: glusterfsvolume.MountedVolume{Mountpoint: filepath.Join(tmpDir, gvId)},
+				},
+			},
+		},
+	}
+
+	errs := make(chan error, 2)
+	for _, name := range []string{"vol1", "vol2"} {
+		go func(name string) {
+			_, err := d.Mount(&volume.MountRequest{Name: name, ID: "container-" + name})
+			errs <- err
+		}(name)
+	}
+
+	<-started
+	// the state stays available while mounting.
+	if list, err := d.List(); err != nil || len(list.Volumes) != 2 {
+		t.Errorf("Unexpected list %#v, error '%v'", list, err)
+	}
+	close(release)
+
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			t.Errorf("Unexpected error '%v'", err)
+		}
+	}
+	if mounts != 1 {
+		t.Errorf("Expected a single mount, got %v", mounts)
+	}
+	for _, name := range []string{"vol1", "vol2"} {
+		if len(d.state.DockerVolumes[name].MountIds) != 1 {
+			t.Errorf("Unexpected mount ids of %v: %#v", name, d.state.DockerVolumes[name].MountIds)
+		}
+	}
+}
+
+func TestDefaultOptionsOverride(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "glusterfs-plugin-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	e := executor{}
+	glusterfsvolume.ExecuteCommand = e.exec
+
+	d := Driver{
+		root:  tmpDir,
+		store: newStateFile(filepath.Join(tmpDir, "test-state.json")),
+		glusterConfig: glusterfsvolume.Config{
+			Servers:       "server1",
+			Options:       map[string]string{"log-level": "ERROR", "acl": ""},
+			LockedOptions: map[string]bool{"acl": true},
+		},
+		state: State{
+			DockerVolumes:  map[string]*DockerVolume{},
+			GlusterVolumes: glusterfsvolume.State{},
+		},
+	}
+
+	if err := d.Create(&volume.CreateRequest{
+		Name:    "test",
+		Options: map[string]string{"log-level": "DEBUG"},
+	}); err != nil {
+		t.Fatalf("Unexpected error '%v'", err)
+	}
+
+	r, err := d.Get(&volume.GetRequest{Name: "test"})
+	if err != nil {
+		t.Fatalf("Unexpected error '%v'", err)
+	}
+	if options := r.Volume.Status["options"]; options != "acl,log-level=DEBUG" {
+		t.Errorf("Unexpected effective options '%v'", options)
+	}
+
+	if err := d.Create(&volume.CreateRequest{
+		Name:    "test2",
+		Options: map[string]string{"acl": "false"},
+	}); err == nil {
+		t.Error("Overriding locked option should return error")
+	}
+}
+
+func TestMountGroups(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "glusterfs-plugin-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	e := executor{}
+	glusterfsvolume.ExecuteCommand = e.exec
+
+	d := Driver{
+		root:  tmpDir,
+		store: newStateFile(filepath.Join(tmpDir, "test-state.json")),
+		glusterConfig: glusterfsvolume.Config{
+			Servers:    "server1",
+			VolumeName: "myvol",
+		},
+		state: State{
+			DockerVolumes:  map[string]*DockerVolume{},
+			GlusterVolumes: glusterfsvolume.State{},
+		},
+	}
+
+	for name, group := range map[string]string{"a1": "stack", "a2": "stack", "b": ""} {
+		r := &volume.CreateRequest{Name: name, Options: map[string]string{}}
+		if group != "" {
+			r.Options["mount-group"] = group
+		}
+		if err := d.Create(r); err != nil {
+			t.Fatalf("Unexpected error '%v'", err)
+		}
+	}
+
+	groupId := d.state.DockerVolumes["a1"].GlusterVolumeId
+	if groupId != "_groups/stack/server1/myvol" || d.state.DockerVolumes["a2"].GlusterVolumeId != groupId {
+		t.Errorf("Group members should share mount, got '%v' and '%v'",
+			groupId, d.state.DockerVolumes["a2"].GlusterVolumeId)
+	}
+	if id := d.state.DockerVolumes["b"].GlusterVolumeId; id != "server1/myvol" {
+		t.Errorf("Unexpected mount '%v' for volume out of group", id)
+	}
+	if group := d.state.GlusterVolumes[groupId].Group; group != "stack" {
+		t.Errorf("Unexpected group '%v'", group)
+	}
+
+	if err := d.Create(&volume.CreateRequest{Name: "c", Options: map[string]string{"mount-group": "../x"}}); err == nil {
+		t.Error("Invalid group name should return error")
+	}
+
+	for _, name := range []string{"a1", "a2"} {
+		if _, ok := d.state.GlusterVolumes[groupId]; !ok {
+			t.Errorf("Group mount removed before its last member")
+		}
+		if err := d.Remove(&volume.RemoveRequest{Name: name}); err != nil {
+			t.Fatalf("Unexpected error '%v'", err)
+		}
+	}
+	if _, ok := d.state.GlusterVolumes[groupId]; ok {
+		t.Errorf("Group mount not removed with its last member")
+	}
+}
+
+func TestNativeSubdirMount(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "glusterfs-plugin-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	var mounts []string
+	glusterfsvolume.ExecuteCommand = func(_ context.Context, cmd string, args ...string) ([]byte, error) {
+		if cmd == "mount" {
+			mounts = append(mounts, args[2])
+		}
+		return []byte{}, nil
+	}
+
+	d := Driver{
+		root:  tmpDir,
+		store: newStateFile(filepath.Join(tmpDir, "test-state.json")),
+		glusterConfig: glusterfsvolume.Config{
+			Servers:    "server1",
+			VolumeName: "myvol",
+		},
+		state: State{
+			DockerVolumes:  map[string]*DockerVolume{},
+			GlusterVolumes: glusterfsvolume.State{},
+		},
+	}
+
+	r := &volume.CreateRequest{Name: "test", Options: map[string]string{"native-subdir": ""}}
+	if err := d.Create(r); err != nil {
+		t.Fatalf("Unexpected error '%v'", err)
+	}
+
+	// the subdir is created through the volume mount, then mounted natively.
+	if expected := []string{"server1:/myvol", "server1:/myvol/test"}; !reflect.DeepEqual(mounts, expected) {
+		t.Errorf("Unexpected mounts %v, expected %v", mounts, expected)
+	}
+
+	v := d.state.DockerVolumes["test"]
+	gv := d.state.GlusterVolumes[v.GlusterVolumeId]
+	if v.GlusterVolumeId != "_subdirs/server1/myvol/test" || gv.Subdir != "test" {
+		t.Errorf("Unexpected gluster volume '%v' %#v", v.GlusterVolumeId, gv)
+	}
+	if v.Mountpoint != gv.Mountpoint {
+		t.Errorf("Unexpected mount point '%v', expected '%v'", v.Mountpoint, gv.Mountpoint)
+	}
+	if _, ok := d.state.GlusterVolumes["server1/myvol"]; ok {
+		t.Error("Volume mount used to create the subdir should be released")
+	}
+}
+
+func TestBindMountFlags(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "glusterfs-plugin-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	glusterfsvolume.MountInfoPath = filepath.Join(tmpDir, "mountinfo")
+	defer func() { glusterfsvolume.MountInfoPath = "/proc/self/mountinfo" }()
+	if err := ioutil.WriteFile(glusterfsvolume.MountInfoPath, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var commands [][]string
+	glusterfsvolume.ExecuteCommand = func(_ context.Context, cmd string, args ...string) ([]byte, error) {
+		commands = append(commands, append([]string{cmd}, args...))
+		return []byte{}, nil
+	}
+
+	d := Driver{
+		root:  tmpDir,
+		store: newStateFile(filepath.Join(tmpDir, "test-state.json")),
+		glusterConfig: glusterfsvolume.Config{
+			Servers:    "server1",
+			VolumeName: "myvol",
+		},
+		state: State{
+			DockerVolumes:  map[string]*DockerVolume{},
+			GlusterVolumes: glusterfsvolume.State{},
+		},
+	}
+
+	if err := d.Create(&volume.CreateRequest{Name: "reader", Options: map[string]string{"ro": "", "nosuid": ""}}); err != nil {
+		t.Fatalf("Unexpected error '%v'", err)
+	}
+	if err := d.Create(&volume.CreateRequest{Name: "writer"}); err != nil {
+		t.Fatalf("Unexpected error '%v'", err)
+	}
+
+	reader, writer := d.state.DockerVolumes["reader"], d.state.DockerVolumes["writer"]
+	if reader.GlusterVolumeId != writer.GlusterVolumeId {
+		t.Errorf("Volumes should share gluster mount, got '%v' and '%v'",
+			reader.GlusterVolumeId, writer.GlusterVolumeId)
+	}
+	gv := d.state.GlusterVolumes[reader.GlusterVolumeId]
+	source := filepath.Join(gv.Mountpoint, "reader")
+	mountpoint := filepath.Join(tmpDir, "_binds", "reader")
+	if reader.BindSource != source || reader.Mountpoint != mountpoint ||
+		!reflect.DeepEqual(reader.BindFlags, []string{"nosuid", "ro"}) {
+		t.Errorf("Unexpected volume %#v", reader)
+	}
+	if writer.BindSource != "" {
+		t.Errorf("Unexpected bind mount of volume without flags %#v", writer)
+	}
+
+	commands = nil
+	if _, err := d.Mount(&volume.MountRequest{Name: "reader", ID: "container1"}); err != nil {
+		t.Fatalf("Unexpected error '%v'", err)
+	}
+	expected := [][]string{
+		{"mount", "--bind", source, mountpoint},
+		{"mount", "-o", "remount,bind,nosuid,ro", mountpoint},
+	}
+	if len(commands) != 3 || !reflect.DeepEqual(commands[1:], expected) {
+		t.Errorf("Unexpected commands %v", commands)
+	}
+	if status := d.state.volumeStatus(reader); status["bind-flags"] != "nosuid,ro" {
+		t.Errorf("Unexpected status %v", status)
+	}
+
+	if err := ioutil.WriteFile(glusterfsvolume.MountInfoPath, []byte(
+		"98 22 0:45 / "+gv.Mountpoint+" rw - fuse.glusterfs server1:/myvol rw\n"+
+			"99 22 0:45 /reader "+mountpoint+" ro,nosuid - fuse.glusterfs server1:/myvol rw\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	commands = nil
+	if err := d.Unmount(&volume.UnmountRequest{Name: "reader", ID: "container1"}); err != nil {
+		t.Fatalf("Unexpected error '%v'", err)
+	}
+	expected = [][]string{{"umount", mountpoint}, {"umount", gv.Mountpoint}}
+	if !reflect.DeepEqual(commands, expected) {
+		t.Errorf("Unexpected commands %v, expected %v", commands, expected)
+	}
+}
+
+func TestSubdirAttrs(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "glusterfs-plugin-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	e := executor{}
+	glusterfsvolume.ExecuteCommand = e.exec
+
+	d := Driver{
+		root:  tmpDir,
+		store: newStateFile(filepath.Join(tmpDir, "test-state.json")),
+		glusterConfig: glusterfsvolume.Config{
+			Servers:    "server1",
+			VolumeName: "myvol",
+		},
+		state: State{
+			DockerVolumes:  map[string]*DockerVolume{},
+			GlusterVolumes: glusterfsvolume.State{},
+		},
+	}
+
+	options := map[string]string{"uid": "0", "mode": "0770"}
+	if err := d.Create(&volume.CreateRequest{Name: "test", Options: options}); err != nil {
+		t.Fatalf("Unexpected error '%v'", err)
+	}
+	v := d.state.DockerVolumes["test"]
+	if v.DirAttrs == nil || *v.DirAttrs != (glusterfsvolume.DirAttrs{Uid: "0", Mode: "0770"}) {
+		t.Errorf("Unexpected attributes %#v", v.DirAttrs)
+	}
+	if fi, err := os.Stat(v.Mountpoint); err != nil || fi.Mode().Perm() != 0770 {
+		t.Errorf("Unexpected mode %v, '%v'", fi.Mode(), err)
+	}
+	if status := d.state.volumeStatus(v); status["dir-attrs"] != "uid=0,mode=0770" {
+		t.Errorf("Unexpected status %v", status)
+	}
+
+	// attributes are not applied to existing subdirs.
+	existing := filepath.Join(filepath.Dir(v.Mountpoint), "existing")
+	if err := os.Mkdir(existing, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Create(&volume.CreateRequest{Name: "existing", Options: options}); err != nil {
+		t.Fatalf("Unexpected error '%v'", err)
+	}
+	if v := d.state.DockerVolumes["existing"]; v.DirAttrs != nil {
+		t.Errorf("Unexpected attributes %#v", v.DirAttrs)
+	}
+	if fi, err := os.Stat(existing); err != nil || fi.Mode().Perm() != 0700 {
+		t.Errorf("Existing subdir mode changed to %v", fi.Mode())
+	}
+
+	if err := d.Create(&volume.CreateRequest{Name: "bad", Options: map[string]string{"mode": "999"}}); err == nil {
+		t.Error("Invalid mode should return error")
+	}
+}
+
+func TestSubdirQuota(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "glusterfs-plugin-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	var quotaCommands [][]string
+	glusterfsvolume.ExecuteCommand = func(_ context.Context, cmd string, args ...string) ([]byte, error) {
+		if cmd != "gluster" {
+			return []byte{}, nil
+		}
+		quotaCommands = append(quotaCommands, args)
+		if args[len(args)-2] == "/failing" {
+			return []byte("quota command failed"), errors.New("exit status 1")
+		}
+		return []byte(`<cliOutput><opRet>0</opRet><volQuota><limit>
+			<path>/test</path><hard_limit>10737418240</hard_limit>
+			<used_space>1024</used_space><avail_space>10737417216</avail_space>
+			</limit></volQuota></cliOutput>`), nil
+	}
+
+	d := Driver{
+		root:  tmpDir,
+		store: newStateFile(filepath.Join(tmpDir, "test-state.json")),
+		glusterConfig: glusterfsvolume.Config{
+			Servers:    "server1",
+			VolumeName: "myvol",
+		},
+		state: State{
+			DockerVolumes:  map[string]*DockerVolume{},
+			GlusterVolumes: glusterfsvolume.State{},
+		},
+	}
+
+	if err := d.Create(&volume.CreateRequest{Name: "test", Options: map[string]string{"size": "10g"}}); err != nil {
+		t.Fatalf("Unexpected error '%v'", err)
+	}
+	expected := [][]string{{"--mode=script", "--remote-host=server1", "volume", "quota", "myvol", "limit-usage", "/test", "10GB"}}
+	if !reflect.DeepEqual(quotaCommands, expected) {
+		t.Errorf("Unexpected gluster commands %v", quotaCommands)
+	}
+
+	r, err := d.Get(&volume.GetRequest{Name: "test"})
+	if err != nil {
+		t.Fatalf("Unexpected error '%v'", err)
+	}
+	if status := r.Volume.Status; status["size"] != "10GB" || status["quota-used"] != uint64(1024) {
+		t.Errorf("Unexpected status %v", status)
+	}
+
+	if err := d.Create(&volume.CreateRequest{Name: "failing", Options: map[string]string{"size": "1g"}}); err == nil {
+		t.Error("Failing to set the quota should return error")
+	}
+	gvMountpoint := d.state.GlusterVolumes["server1/myvol"].Mountpoint
+	if _, err := os.Stat(filepath.Join(gvMountpoint, "failing")); !os.IsNotExist(err) {
+		t.Errorf("Subdir of the failed volume not removed: %v", err)
+	}
+
+	if err := d.Create(&volume.CreateRequest{Name: "bad", Options: map[string]string{"size": "lots"}}); err == nil {
+		t.Error("Invalid size should return error")
+	}
+}
+
+func TestProvisioning(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "glusterfs-plugin-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	var glusterCommands [][]string
+	glusterfsvolume.ExecuteCommand = func(_ context.Context, cmd string, args ...string) ([]byte, error) {
+		if cmd != "gluster" {
+			return []byte{}, nil
+		}
+		glusterCommands = append(glusterCommands, args[2:])
+		if args[3] == "info" && args[4] == "new" {
+			return []byte("Volume new does not exist"), errors.New("exit status 1")
+		}
+		return []byte("<cliOutput><opRet>0</opRet><volInfo><volumes><count>1</count></volumes></volInfo></cliOutput>"), nil
+	}
+
+	d := Driver{
+		root:  tmpDir,
+		store: newStateFile(filepath.Join(tmpDir, "test-state.json")),
+		glusterConfig: glusterfsvolume.Config{
+			Servers: "server1",
+		},
+		provisioning: &glusterfsvolume.Provisioning{
+			Bricks:   []string{"server1:/bricks", "server2:/bricks"},
+			Replica:  2,
+			OnRemove: "delete",
+		},
+		state: State{
+			DockerVolumes:  map[string]*DockerVolume{},
+			GlusterVolumes: glusterfsvolume.State{},
+		},
+	}
+
+	for _, name := range []string{"new", "existing"} {
+		if err := d.Create(&volume.CreateRequest{Name: name}); err != nil {
+			t.Fatalf("Unexpected error '%v'", err)
+		}
+	}
+	expected := [][]string{
+		{"volume", "info", "new", "--xml"},
+		{"volume", "create", "new", "replica", "2", "server1:/bricks/new", "server2:/bricks/new"},
+		{"volume", "start", "new"},
+		{"volume", "info", "existing", "--xml"},
+	}
+	if !reflect.DeepEqual(glusterCommands, expected) {
+		t.Errorf("Unexpected gluster commands %v", glusterCommands)
+	}
+	if !d.state.DockerVolumes["new"].Provisioned || d.state.DockerVolumes["existing"].Provisioned {
+		t.Error("Only the created volume should be provisioned")
+	}
+
+	for _, name := range []string{"new", "existing"} {
+		glusterCommands = nil
+		if err := d.Remove(&volume.RemoveRequest{Name: name}); err != nil {
+			t.Fatalf("Unexpected error '%v'", err)
+		}
+		expected = nil
+		if name == "new" {
+			expected = [][]string{{"volume", "stop", "new"}, {"volume", "delete", "new"}}
+		}
+		if !reflect.DeepEqual(glusterCommands, expected) {
+			t.Errorf("Unexpected gluster commands removing '%v': %v", name, glusterCommands)
+		}
+	}
+}
+
+func TestRemovePolicy(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "glusterfs-plugin-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	e := executor{}
+	glusterfsvolume.ExecuteCommand = e.exec
+
+	d := Driver{
+		root:  tmpDir,
+		store: newStateFile(filepath.Join(tmpDir, "test-state.json")),
+		glusterConfig: glusterfsvolume.Config{
+			Servers:    "server1",
+			VolumeName: "myvol",
+		},
+		removePolicy:   glusterfsvolume.TrashData,
+		trashRetention: time.Hour,
+		state: State{
+			DockerVolumes:  map[string]*DockerVolume{},
+			GlusterVolumes: glusterfsvolume.State{},
+		},
+	}
+
+	volumes := map[string]string{"trashed": "", "deleted": "delete", "retained": "retain"}
+	for name, policy := range volumes {
+		r := &volume.CreateRequest{Name: name, Options: map[string]string{}}
+		if policy != "" {
+			r.Options["on-remove"] = policy
+		}
+		if err := d.Create(r); err != nil {
+			t.Fatalf("Unexpected error '%v'", err)
+		}
+	}
+	gvMountpoint := d.state.GlusterVolumes["server1/myvol"].Mountpoint
+
+	for name := range volumes {
+		if err := d.Remove(&volume.RemoveRequest{Name: name}); err != nil {
+			t.Fatalf("Unexpected error '%v'", err)
+		}
+	}
+
+	entries, _ := ioutil.ReadDir(gvMountpoint)
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	if !reflect.DeepEqual(names, []string{".trash", "retained"}) {
+		t.Errorf("Unexpected subdirs %v", names)
+	}
+	if trashed, _ := ioutil.ReadDir(filepath.Join(gvMountpoint, ".trash")); len(trashed) != 1 {
+		t.Errorf("Unexpected trash %v", trashed)
+	}
+	if _, ok := d.state.Trashes["server1/myvol"]; !ok || len(d.state.GlusterVolumes) != 0 {
+		t.Errorf("Unexpected trashes %v, gluster volumes %v", d.state.Trashes, d.state.GlusterVolumes)
+	}
+
+	// the unused gluster volume is mounted again to purge its trash.
+	d.trashRetention = time.Nanosecond
+	e.cmd = ""
+	d.purgeTrash(context.Background())
+	if e.cmd != "mount" {
+		t.Errorf("Gluster volume not mounted to purge the trash")
+	}
+	if trashed, _ := ioutil.ReadDir(filepath.Join(gvMountpoint, ".trash")); len(trashed) != 0 {
+		t.Errorf("Trash not purged %v", trashed)
+	}
+	if len(d.state.Trashes) != 0 || len(d.state.GlusterVolumes) != 0 {
+		t.Errorf("Unexpected trashes %v, gluster volumes %v", d.state.Trashes, d.state.GlusterVolumes)
+	}
+
+	if err := d.Create(&volume.CreateRequest{Name: "bad", Options: map[string]string{"on-remove": "shred"}}); err == nil {
+		t.Error("Invalid policy should return error")
+	}
+}
+
+func TestRemovePolicyFailure(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "glusterfs-plugin-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	e := executor{}
+	glusterfsvolume.ExecuteCommand = e.exec
+
+	statePath := filepath.Join(tmpDir, "test-state.json")
+	d := Driver{
+		root:  tmpDir,
+		store: newStateFile(statePath),
+		glusterConfig: glusterfsvolume.Config{
+			Servers:    "server1",
+			VolumeName: "myvol",
+		},
+		removePolicy: glusterfsvolume.TrashData,
+		state: State{
+			DockerVolumes:  map[string]*DockerVolume{},
+			GlusterVolumes: glusterfsvolume.State{},
+		},
+	}
+
+	if err := d.Create(&volume.CreateRequest{Name: "test"}); err != nil {
+		t.Fatalf("Unexpected error '%v'", err)
+	}
+	gvMountpoint := d.state.GlusterVolumes["server1/myvol"].Mountpoint
+	// the trash can not be created.
+	trash := filepath.Join(gvMountpoint, glusterfsvolume.TrashDir)
+	if err := ioutil.WriteFile(trash, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := d.Remove(&volume.RemoveRequest{Name: "test"}); err == nil {
+		t.Fatal("Failing to trash the volume data should return error")
+	}
+	if _, err := os.Stat(filepath.Join(gvMountpoint, "test")); err != nil {
+		t.Errorf("Volume data lost: %v", err)
+	}
+	d2 := Driver{store: newStateFile(statePath)}
+	if err := d2.LoadState(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := d2.state.DockerVolumes["test"]; !ok {
+		t.Error("Volume not kept in state after failed removal")
+	}
+	if _, ok := d2.state.GlusterVolumes["server1/myvol"]; !ok {
+		t.Error("Gluster volume not kept in state after failed removal")
+	}
+
+	os.Remove(trash)
+	if err := d.Remove(&volume.RemoveRequest{Name: "test"}); err != nil {
+		t.Fatalf("Unexpected error retrying removal '%v'", err)
+	}
+	if _, ok := d.state.DockerVolumes["test"]; ok {
+		t.Error("Volume still in state after removal")
+	}
+}
+
+func TestCloneVolume(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "glusterfs-plugin-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	var copies [][]string
+	copyFails := false
+	glusterfsvolume.ExecuteCommand = func(_ context.Context, cmd string, args ...string) ([]byte, error) {
+		if cmd == "cp" {
+			copies = append(copies, args)
+			if copyFails {
+				return []byte("No space left on device"), errors.New("exit status 1")
+			}
+		}
+		return []byte{}, nil
+	}
+
+	d := Driver{
+		root:  tmpDir,
+		store: newStateFile(filepath.Join(tmpDir, "test-state.json")),
+		glusterConfig: glusterfsvolume.Config{
+			Servers:    "server1",
+			VolumeName: "myvol",
+		},
+		state: State{
+			DockerVolumes:  map[string]*DockerVolume{},
+			GlusterVolumes: glusterfsvolume.State{},
+		},
+	}
+
+	if err := d.Create(&volume.CreateRequest{Name: "prod"}); err != nil {
+		t.Fatalf("Unexpected error '%v'", err)
+	}
+	if err := d.Create(&volume.CreateRequest{Name: "staging", Options: map[string]string{"from": "prod"}}); err != nil {
+		t.Fatalf("Unexpected error '%v'", err)
+	}
+
+	prod, staging := d.state.DockerVolumes["prod"], d.state.DockerVolumes["staging"]
+	expected := [][]string{{"-a", "--preserve=all", prod.Mountpoint + "/.", staging.Mountpoint}}
+	if !reflect.DeepEqual(copies, expected) {
+		t.Errorf("Unexpected copies %v", copies)
+	}
+	if status := d.state.volumeStatus(staging); status["cloned-from"] != "prod" {
+		t.Errorf("Unexpected status %v", status)
+	}
+
+	if err := d.Create(&volume.CreateRequest{Name: "other", Options: map[string]string{"from": "missing"}}); err == nil {
+		t.Error("Cloning a missing volume should return error")
+	}
+	// existing data is not merged with the clone.
+	if err := os.Mkdir(filepath.Join(filepath.Dir(prod.Mountpoint), "existing"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Create(&volume.CreateRequest{Name: "existing", Options: map[string]string{"from": "prod"}}); err == nil {
+		t.Error("Cloning into an existing subdir should return error")
+	}
+	// failed clones into natively mounted subdirs are removed through the
+	// whole volume, so that they can be retried.
+	native := &volume.CreateRequest{Name: "native", Options: map[string]string{"from": "prod", "native-subdir": ""}}
+	copyFails = true
+	if err := d.Create(native); err == nil {
+		t.Error("Failed clone should return error")
+	}
+	if _, err := os.Lstat(filepath.Join(filepath.Dir(prod.Mountpoint), "native")); !os.IsNotExist(err) {
+		t.Errorf("Failed clone left behind: %v", err)
+	}
+	copyFails = false
+	if err := d.Create(native); err != nil {
+		t.Errorf("Unexpected error '%v' retrying clone", err)
+	}
+
+	// cloning a volume into itself or crosswise must not deadlock.
+	done := make(chan error, 3)
+	go func() {
+		done <- d.Create(&volume.CreateRequest{Name: "prod", Options: map[string]string{"from": "prod"}})
+	}()
+	go func() {
+		done <- d.Create(&volume.CreateRequest{Name: "x", Options: map[string]string{"from": "y"}})
+	}()
+	go func() {
+		done <- d.Create(&volume.CreateRequest{Name: "y", Options: map[string]string{"from": "x"}})
+	}()
+	// none of the sources exist.
+	for i := 0; i < 3; i++ {
+		select {
+		case err := <-done:
+			if err == nil {
+				t.Error("Cloning should return error")
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Cloning deadlocked")
+		}
+	}
+	if _, err := d.Get(&volume.GetRequest{Name: "prod"}); err != nil {
+		t.Errorf("Unexpected error '%v'", err)
+	}
+}
+
+func TestInitFrom(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "glusterfs-plugin-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	var copies [][]string
+	glusterfsvolume.ExecuteCommand = func(_ context.Context, cmd string, args ...string) ([]byte, error) {
+		if cmd == "cp" {
+			copies = append(copies, args)
+		}
+		return []byte{}, nil
+	}
+
+	d := Driver{
+		root:  tmpDir,
+		store: newStateFile(filepath.Join(tmpDir, "test-state.json")),
+		glusterConfig: glusterfsvolume.Config{
+			Servers:    "server1",
+			VolumeName: "myvol",
+		},
+		state: State{
+			DockerVolumes:  map[string]*DockerVolume{},
+			GlusterVolumes: glusterfsvolume.State{},
+		},
+	}
+
+	if err := d.Create(&volume.CreateRequest{Name: "first"}); err != nil {
+		t.Fatalf("Unexpected error '%v'", err)
+	}
+	root := filepath.Dir(d.state.DockerVolumes["first"].Mountpoint)
+	if err := os.MkdirAll(filepath.Join(root, "templates", "app"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	options := map[string]string{"init-from": "templates/app"}
+	if err := d.Create(&volume.CreateRequest{Name: "app", Options: options}); err != nil {
+		t.Fatalf("Unexpected error '%v'", err)
+	}
+	app := d.state.DockerVolumes["app"]
+	expected := [][]string{{"-a", "--preserve=all", filepath.Join(root, "templates", "app") + "/.", app.Mountpoint}}
+	if !reflect.DeepEqual(copies, expected) {
+		t.Errorf("Unexpected copies %v", copies)
+	}
+	if status := d.state.volumeStatus(app); status["init-from"] != "templates/app" {
+		t.Errorf("Unexpected status %v", status)
+	}
+
+	// existing data is not initialized.
+	if err := os.Mkdir(filepath.Join(root, "existing"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	copies = nil
+	if err := d.Create(&volume.CreateRequest{Name: "existing", Options: options}); err != nil {
+		t.Fatalf("Unexpected error '%v'", err)
+	}
+	if len(copies) != 0 || d.state.DockerVolumes["existing"].InitFrom != "" {
+		t.Errorf("Existing subdir should not be initialized, copies %v", copies)
+	}
+
+	for _, options := range []map[string]string{
+		{"init-from": "templates/missing"},
+		{"init-from": "../app"},
+		{"init-from": "templates/app", "from": "first"},
+	} {
+		if err := d.Create(&volume.CreateRequest{Name: "failed", Options: options}); err == nil {
+			t.Errorf("Options %v should return error", options)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(root, "failed")); !os.IsNotExist(err) {
+		t.Errorf("Failed volume subdir should be removed, got '%v'", err)
+	}
+}
+
+func TestDiscovery(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "glusterfs-plugin-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	mounts := 0
+	glusterfsvolume.ExecuteCommand = func(_ context.Context, cmd string, args ...string) ([]byte, error) {
+		if cmd == "mount" {
+			mounts++
+		}
+		return []byte{}, nil
+	}
+
+	d := Driver{
+		root:  tmpDir,
+		store: newStateFile(filepath.Join(tmpDir, "test-state.json")),
+		glusterConfig: glusterfsvolume.Config{
+			Servers:    "server1",
+			VolumeName: "myvol",
+		},
+		discover: true,
+		state: State{
+			DockerVolumes:  map[string]*DockerVolume{},
+			GlusterVolumes: glusterfsvolume.State{},
+		},
+	}
+
+	if err := d.Create(&volume.CreateRequest{Name: "mine"}); err != nil {
+		t.Fatalf("Unexpected error '%v'", err)
+	}
+	root := filepath.Dir(d.state.DockerVolumes["mine"].Mountpoint)
+	for _, dir := range []string{"other", glusterfsvolume.TrashDir, ".docker-volumes", "_hidden"} {
+		if err := os.Mkdir(filepath.Join(root, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(root, "file"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := d.List()
+	if err != nil {
+		t.Fatalf("Unexpected error '%v'", err)
+	}
+	var names []string
+	for _, v := range resp.Volumes {
+		names = append(names, v.Name)
+	}
+	sort.Strings(names)
+	if !reflect.DeepEqual(names, []string{"mine", "other"}) {
+		t.Errorf("Unexpected volumes %v", names)
+	}
+	if _, ok := d.state.DockerVolumes["other"]; ok {
+		t.Error("Listed volume should not be adopted")
+	}
+
+	// listings reuse the subdirs discovered instead of mounting again.
+	if err := os.Mkdir(filepath.Join(root, "later"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	mounts = 0
+	if resp, err := d.List(); err != nil || len(resp.Volumes) != 2 || mounts != 0 {
+		t.Errorf("Unexpected volumes %v, '%v', %d mounts", resp.Volumes, err, mounts)
+	}
+
+	// without adoption, discovered volumes are left to their owner.
+	get, err := d.Get(&volume.GetRequest{Name: "other"})
+	if err != nil {
+		t.Fatalf("Unexpected error '%v'", err)
+	}
+	if expected := map[string]interface{}{"origin": "discovered", "adopted": false}; !reflect.DeepEqual(get.Volume.Status, expected) {
+		t.Errorf("Unexpected status %v", get.Volume.Status)
+	}
+	if _, err := d.Path(&volume.PathRequest{Name: "other"}); err == nil {
+		t.Error("Path of a volume not adopted should return error")
+	}
+	if _, err := d.Mount(&volume.MountRequest{Name: "other", ID: "container"}); err == nil {
+		t.Error("Mounting a volume not adopted should return error")
+	}
+	if _, ok := d.state.DockerVolumes["other"]; ok {
+		t.Error("Volume should not be adopted without discover=adopt")
+	}
+
+	d.adopt = true
+	get, err = d.Get(&volume.GetRequest{Name: "other"})
+	if err != nil {
+		t.Fatalf("Unexpected error '%v'", err)
+	}
+	if get.Volume.Mountpoint != filepath.Join(root, "other") || get.Volume.Status["origin"] != "discovered" {
+		t.Errorf("Unexpected volume %#v", get.Volume)
+	}
+	if v, ok := d.state.DockerVolumes["other"]; !ok || !v.Discovered {
+		t.Errorf("Discovered volume should be adopted, got %#v", v)
+	}
+	if get, err := d.Get(&volume.GetRequest{Name: "mine"}); err != nil || get.Volume.Status["origin"] != nil {
+		t.Errorf("Unexpected volume %#v, '%v'", get.Volume, err)
+	}
+
+	for _, name := range []string{"missing", "file", "_hidden", glusterfsvolume.TrashDir} {
+		if _, err := d.Get(&volume.GetRequest{Name: name}); err == nil {
+			t.Errorf("Getting '%v' should return error", name)
+		}
+		if _, ok := d.state.DockerVolumes[name]; ok {
+			t.Errorf("'%v' should not be adopted", name)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(root, "missing")); !os.IsNotExist(err) {
+		t.Errorf("Missing subdir should not be created, got '%v'", err)
+	}
+}
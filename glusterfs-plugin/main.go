@@ -1,11 +1,12 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/docker/go-plugins-helpers/volume"
 	"github.com/sirupsen/logrus"
@@ -15,6 +16,13 @@ import (
 
 const socketAddress = "/run/docker/plugins/glusterfs.sock"
 
+const defaultMonitorInterval = 30 * time.Second
+
+const defaultTrashRetention = 7 * 24 * time.Hour
+
+// trashPurgeInterval is the delay between two purges of the trashes.
+const trashPurgeInterval = time.Hour
+
 func NewDriver(root string) (*Driver, error) {
 	logrus.WithField("method", "new glusterfs driver").Debug(root)
 
@@ -29,13 +37,7 @@ func NewDriver(root string) (*Driver, error) {
 		if len(kv) == 1 {
 			kv = append(kv, "")
 		}
-		switch kv[0] {
-		default:
-			if err := glusterfsvolume.CheckOption(kv[0], kv[1]); err != nil {
-				return nil, err
-			}
-			options[kv[0]] = kv[1]
-		}
+		options[kv[0]] = kv[1]
 	}
 
 	loglevel := os.Getenv("LOGLEVEL")
@@ -67,15 +69,132 @@ func NewDriver(root string) (*Driver, error) {
 	_, dedicatedMounts := options["dedicated-mount"]
 	delete(options, "dedicated-mount")
 
+	_, resolveServers := options["resolve-servers"]
+	delete(options, "resolve-servers")
+
+	_, nativeSubdir := options["native-subdir"]
+	delete(options, "native-subdir")
+
+	discover, adopt := false, false
+	if val, ok := options["discover"]; ok {
+		switch val {
+		case "":
+		case "adopt":
+			adopt = true
+		default:
+			return nil, fmt.Errorf("invalid 'discover' option '%v', expected no value or 'adopt'", val)
+		}
+		discover = true
+	}
+	delete(options, "discover")
+	if discover && volumeName == "" {
+		return nil, errors.New("'discover' option requires 'VOLUME_NAME' to be set")
+	}
+
+	stateStore, _ := options["state-store"]
+	delete(options, "state-store")
+
+	monitorInterval := defaultMonitorInterval
+	if interval, ok := options["monitor-interval"]; ok {
+		var err error
+		if monitorInterval, err = time.ParseDuration(interval); err != nil {
+			return nil, fmt.Errorf("invalid 'monitor-interval' option: %v", err)
+		}
+		delete(options, "monitor-interval")
+	}
+
+	removePolicy := glusterfsvolume.RetainData
+	if policy, ok := options["on-remove"]; ok {
+		if err := glusterfsvolume.CheckRemovePolicy(policy); err != nil {
+			return nil, err
+		}
+		removePolicy = policy
+		delete(options, "on-remove")
+	}
+
+	trashRetention := defaultTrashRetention
+	if retention, ok := options["trash-retention"]; ok {
+		var err error
+		if trashRetention, err = time.ParseDuration(retention); err != nil {
+			return nil, fmt.Errorf("invalid 'trash-retention' option: %v", err)
+		}
+		delete(options, "trash-retention")
+	}
+
+	for _, cmd := range []string{"mount", "umount", "gluster"} {
+		if val, ok := options[cmd+"-timeout"]; ok {
+			timeout, err := time.ParseDuration(val)
+			if err != nil {
+				return nil, fmt.Errorf("invalid '%v-timeout' option: %v", cmd, err)
+			}
+			glusterfsvolume.Timeouts[cmd] = timeout
+			delete(options, cmd+"-timeout")
+		}
+	}
+
+	scope, _ := options["scope"]
+	delete(options, "scope")
+
+	globalScope := false
+	switch scope {
+	case "", "local":
+	case "global":
+		if stateStore == "" {
+			stateStore = "gluster"
+		} else if stateStore != "gluster" {
+			return nil, errors.New("'scope=global' option requires 'state-store=gluster'")
+		}
+		globalScope = true
+	default:
+		return nil, fmt.Errorf("unknown scope '%v'", scope)
+	}
+
+	provisioning, err := glusterfsvolume.ParseProvisioning(options)
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range glusterfsvolume.ProvisioningOptions {
+		delete(options, key)
+	}
+
+	// remaining options are gluster mount options.
+	for key, val := range options {
+		if err := glusterfsvolume.CheckOption(key, val); err != nil {
+			return nil, err
+		}
+	}
+
+	lockedOptions, err := glusterfsvolume.ParseLockedOptions(os.Getenv("LOCKED_OPTIONS"))
+	if err != nil {
+		return nil, err
+	}
+
+	glusterConfig := glusterfsvolume.Config{
+		Servers:        servers,
+		VolumeName:     volumeName,
+		DedicatedMount: dedicatedMounts,
+		ResolveServers: resolveServers,
+		NativeSubdir:   nativeSubdir,
+		Options:        options,
+		LockedOptions:  lockedOptions,
+	}
+
+	store, err := newStateStore(stateStore, root, glusterConfig)
+	if err != nil {
+		return nil, err
+	}
+
 	return &Driver{
-		root:      root,
-		statePath: filepath.Join(root, "glusterfs-state.json"),
-		glusterConfig: glusterfsvolume.Config{
-			Servers:        servers,
-			VolumeName:     volumeName,
-			DedicatedMount: dedicatedMounts,
-			Options:        options,
-		},
+		root:            root,
+		store:           store,
+		monitorInterval: monitorInterval,
+		globalScope:     globalScope,
+		glusterConfig:   glusterConfig,
+		provisioning:    provisioning,
+		removePolicy:    removePolicy,
+		trashRetention:  trashRetention,
+		discover:        discover,
+		adopt:           adopt,
 		state: State{
 			DockerVolumes:  map[string]*DockerVolume{},
 			GlusterVolumes: glusterfsvolume.State{},
@@ -83,16 +202,30 @@ func NewDriver(root string) (*Driver, error) {
 	}, nil
 }
 
+func newStateStore(kind string, root string, config glusterfsvolume.Config) (glusterfsvolume.StateStore, error) {
+	file := newStateFile(filepath.Join(root, "glusterfs-state.json"))
+	return glusterfsvolume.NewStateStore(kind, file, "glusterfs-plugin", root, config)
+}
+
 func main() {
 	d, err := NewDriver("/mnt")
 	if err != nil {
 		logrus.Fatal(err)
 	}
+	if err := d.LoadState(); err != nil {
+		logrus.Fatal(err)
+	}
+	ctx := glusterfsvolume.StopContext()
+	d.ctx = ctx
+	d.Reconcile(ctx)
+	if d.monitorInterval > 0 {
+		go glusterfsvolume.Repeat(ctx, d.monitorInterval, d.checkMounts)
+	}
+	if d.trashRetention > 0 {
+		go glusterfsvolume.Repeat(ctx, trashPurgeInterval, d.purgeTrash)
+	}
+
 	h := volume.NewHandler(d)
 	logrus.Infof("listening on %s", socketAddress)
 	logrus.Error(h.ServeUnix(socketAddress, 0))
 }
-
-func executeCommand(cmd string, args ...string) ([]byte, error) {
-	return exec.Command(cmd, args...).CombinedOutput()
-}
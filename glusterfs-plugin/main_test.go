@@ -3,9 +3,12 @@ package main
 import (
 	"os"
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/sirupsen/logrus"
+
+	"github.com/origin-nexus/docker-volume-glusterfs/glusterfs-volume"
 )
 
 func TestNewDriverUnknownLoglevel(t *testing.T) {
@@ -66,7 +69,8 @@ func TestNewDriverLogruslevel(t *testing.T) {
 func TestUnsupportedOptionsInMain(t *testing.T) {
 	unsupportedOptions := []string{
 		"backup-volfile-server", "backup-volfile-servers", "log-file", "servers",
-		"volume-name", "log-level=ERROR log-file=/whatever"}
+		"volume-name", "log-level=ERROR log-file=/whatever",
+		"provision-replica=3", "provision-bricks=server1:/bricks provision-replica=2"}
 	root := "/myroot"
 
 	for _, option := range unsupportedOptions {
@@ -79,6 +83,24 @@ func TestUnsupportedOptionsInMain(t *testing.T) {
 	}
 }
 
+func TestOPTIONSCheckedAgainstRegistry(t *testing.T) {
+	invalid := map[string]string{
+		"log-levle=INFO": "did you mean 'log-level'?",
+		"acl=yes":        "takes no value",
+	}
+	root := "/myroot"
+	defer os.Setenv("OPTIONS", "")
+
+	for option, message := range invalid {
+		os.Setenv("OPTIONS", option)
+		if _, err := NewDriver(root); err == nil {
+			t.Errorf("Option '%v' should return error", option)
+		} else if !strings.Contains(err.Error(), message) {
+			t.Errorf("Option '%v': unexpected error '%v'", option, err)
+		}
+	}
+}
+
 func TestOPTIONvarSetsOptions(t *testing.T) {
 	option_str := "acl log-level=INFO"
 	os.Setenv("OPTIONS", option_str)
@@ -125,3 +147,71 @@ func TestOPTIONdedicatedMount(t *testing.T) {
 			"Dedicated mounts was not activated by 'dedicated-mounts' option")
 	}
 }
+
+func TestOPTIONstateStore(t *testing.T) {
+	root := "/myroot"
+	os.Setenv("SERVERS", "")
+	os.Setenv("VOLUME_NAME", "")
+
+	os.Setenv("OPTIONS", "state-store=unknown")
+	if _, err := NewDriver(root); err == nil {
+		t.Error("Unknown state store should return error")
+	}
+
+	os.Setenv("OPTIONS", "state-store=gluster")
+	if _, err := NewDriver(root); err == nil {
+		t.Error("Gluster state store without SERVERS and VOLUME_NAME should return error")
+	}
+
+	os.Setenv("SERVERS", "server1")
+	os.Setenv("VOLUME_NAME", "myvol")
+	defer os.Setenv("SERVERS", "")
+	defer os.Setenv("VOLUME_NAME", "")
+
+	d, err := NewDriver(root)
+	if err != nil {
+		t.Fatalf("Unexpected error '%v'", err)
+	}
+	if _, ok := d.store.(*glusterfsvolume.GlusterStore); !ok {
+		t.Errorf("Unexpected state store %#v", d.store)
+	}
+	if _, ok := d.GetOptions()["state-store"]; ok {
+		t.Error("'state-store' should not be passed to gluster mounts")
+	}
+}
+
+func TestOPTIONdiscover(t *testing.T) {
+	root := "/myroot"
+	os.Setenv("VOLUME_NAME", "")
+	os.Setenv("OPTIONS", "discover")
+	defer os.Setenv("OPTIONS", "")
+	if _, err := NewDriver(root); err == nil {
+		t.Error("Discovery without VOLUME_NAME should return error")
+	}
+
+	os.Setenv("VOLUME_NAME", "myvol")
+	defer os.Setenv("VOLUME_NAME", "")
+	d, err := NewDriver(root)
+	if err != nil {
+		t.Fatalf("Unexpected error '%v'", err)
+	}
+	if !d.discover || d.adopt {
+		t.Error("Discovery was not enabled by 'discover' option")
+	}
+	if _, ok := d.GetOptions()["discover"]; ok {
+		t.Error("'discover' should not be passed to gluster mounts")
+	}
+
+	os.Setenv("OPTIONS", "discover=adopt")
+	if d, err = NewDriver(root); err != nil {
+		t.Fatalf("Unexpected error '%v'", err)
+	}
+	if !d.discover || !d.adopt {
+		t.Error("Adoption was not enabled by 'discover=adopt' option")
+	}
+
+	os.Setenv("OPTIONS", "discover=all")
+	if _, err := NewDriver(root); err == nil {
+		t.Error("Unknown 'discover' value should return error")
+	}
+}
@@ -0,0 +1,52 @@
+package glusterfsvolume
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// BindFlags are the mount flags a bind mount can add to the mount it binds.
+var BindFlags = map[string]bool{
+	"ro":     true,
+	"nosuid": true,
+	"nodev":  true,
+	"noexec": true,
+}
+
+// BindMount bind mounts source on the mount point, with flags applied to the
+// bind mount only. It does nothing if the mount point is already mounted.
+func (mv *MountedVolume) BindMount(ctx context.Context, source string, flags []string) error {
+	if mv.IsMounted() {
+		return nil
+	}
+
+	for _, flag := range flags {
+		if !BindFlags[flag] {
+			return fmt.Errorf("'%v' is not a bind mount flag", flag)
+		}
+	}
+
+	if err := mv.CreateMountpoint(); err != nil {
+		return fmt.Errorf("error creating mount point: %v", err)
+	}
+
+	output, err := ExecuteCommand(ctx, "mount", "--bind", source, mv.Mountpoint)
+	if err != nil {
+		return fmt.Errorf("mount command execute failed: %w (%s)", err, output)
+	}
+	if len(flags) == 0 {
+		return nil
+	}
+
+	// flags of a bind mount can only be set by remounting it.
+	output, err = ExecuteCommand(ctx, "mount", "-o", "remount,bind,"+strings.Join(flags, ","), mv.Mountpoint)
+	if err != nil {
+		err = fmt.Errorf("mount command execute failed: %w (%s)", err, output)
+		if umountErr := mv.ForceUnmount(ctx); umountErr != nil {
+			return fmt.Errorf("%v, and unmounting failed: %v", err, umountErr)
+		}
+		return err
+	}
+	return nil
+}
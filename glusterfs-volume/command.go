@@ -0,0 +1,138 @@
+package glusterfsvolume
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// CommandRunner runs external commands, killing them when ctx is done.
+type CommandRunner interface {
+	Run(ctx context.Context, cmd string, args ...string) ([]byte, error)
+}
+
+// TimeoutError is returned when a command did not complete in time.
+type TimeoutError struct {
+	Command string
+	Timeout time.Duration
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("'%v' timed out after %v", e.Command, e.Timeout)
+}
+
+// DefaultTimeout applies to commands without an entry in Timeouts.
+var DefaultTimeout = 5 * time.Minute
+
+// Timeouts of commands, commands like mkfs.xfs use the "mkfs" entry.
+var Timeouts = map[string]time.Duration{
+	"mount":  time.Minute,
+	"umount": 30 * time.Second,
+	"mkfs":   30 * time.Minute,
+	// gluster CLI commands, retried on each server.
+	"gluster": time.Minute,
+	// copies of cloned or initialized volumes.
+	"cp":  12 * time.Hour,
+	"tar": 12 * time.Hour,
+}
+
+// Runner runs the commands of ExecuteCommand.
+var Runner CommandRunner = ProcessGroupRunner{}
+
+// ExecuteCommand runs the external commands, stopped when ctx is done.
+var ExecuteCommand = RunCommand
+
+// CommandTimeout returns the timeout of cmd.
+func CommandTimeout(cmd string) time.Duration {
+	if timeout, ok := Timeouts[cmd]; ok {
+		return timeout
+	}
+	if timeout, ok := Timeouts[strings.SplitN(cmd, ".", 2)[0]]; ok {
+		return timeout
+	}
+	return DefaultTimeout
+}
+
+// RunCommand runs cmd with Runner, and returns a *TimeoutError if it did not
+// complete within its timeout, or the error of ctx once it is done.
+func RunCommand(ctx context.Context, cmd string, args ...string) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	atomic.AddInt32(&running, 1)
+	defer atomic.AddInt32(&running, -1)
+
+	timeout := CommandTimeout(cmd)
+	cmdCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	output, err := Runner.Run(cmdCtx, cmd, args...)
+	if ctx.Err() != nil {
+		return output, ctx.Err()
+	}
+	if cmdCtx.Err() == context.DeadlineExceeded {
+		return output, &TimeoutError{Command: cmd, Timeout: timeout}
+	}
+	return output, err
+}
+
+// running counts the commands of RunCommand in progress.
+var running int32
+
+// StopContext returns a context cancelled when the process receives SIGTERM
+// or SIGINT, so that the commands in progress are killed. The process then
+// exits once they are.
+func StopContext() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	go func() {
+		logrus.Infof("Received %v, stopping", <-signals)
+		cancel()
+		for atomic.LoadInt32(&running) != 0 {
+			time.Sleep(10 * time.Millisecond)
+		}
+		os.Exit(0)
+	}()
+	return ctx
+}
+
+// ProcessGroupRunner runs commands in their own process group, so that the
+// whole process tree (e.g. mount and mount.glusterfs) is killed when ctx is
+// done.
+type ProcessGroupRunner struct{}
+
+func (ProcessGroupRunner) Run(ctx context.Context, cmd string, args ...string) ([]byte, error) {
+	var output bytes.Buffer
+
+	c := exec.Command(cmd, args...)
+	c.Stdout = &output
+	c.Stderr = &output
+	c.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	if err := c.Start(); err != nil {
+		return nil, err
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- c.Wait() }()
+
+	select {
+	case err := <-done:
+		return output.Bytes(), err
+	case <-ctx.Done():
+		syscall.Kill(-c.Process.Pid, syscall.SIGKILL)
+		<-done
+		return output.Bytes(), ctx.Err()
+	}
+}
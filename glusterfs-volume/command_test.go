@@ -0,0 +1,70 @@
+package glusterfsvolume
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCommandTimeout(t *testing.T) {
+	cases := map[string]time.Duration{
+		"mount":    Timeouts["mount"],
+		"mkfs.xfs": Timeouts["mkfs"],
+		"truncate": DefaultTimeout,
+	}
+	for cmd, timeout := range cases {
+		if d := CommandTimeout(cmd); d != timeout {
+			t.Errorf("'%v' timeout is %v, expected %v", cmd, d, timeout)
+		}
+	}
+}
+
+func TestRunCommandOutput(t *testing.T) {
+	output, err := RunCommand(context.Background(), "sh", "-c", "echo out; echo err >&2; exit 3")
+	if err == nil {
+		t.Error("Failing command should return error")
+	}
+	if string(output) != "out\nerr\n" {
+		t.Errorf("Unexpected output '%s'", output)
+	}
+}
+
+func TestRunCommandKillsProcessTree(t *testing.T) {
+	Timeouts["sh"] = 100 * time.Millisecond
+	defer delete(Timeouts, "sh")
+
+	start := time.Now()
+	// the pipe is held open by both sleep and cat, they must all be killed.
+	_, err := RunCommand(context.Background(), "sh", "-c", "sleep 30 | cat")
+
+	var timeoutErr *TimeoutError
+	if !errors.As(err, &timeoutErr) {
+		t.Fatalf("Expected timeout error, got '%v'", err)
+	}
+	if timeoutErr.Timeout != 100*time.Millisecond {
+		t.Errorf("Unexpected timeout %v", timeoutErr.Timeout)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("Command not killed on timeout, took %v", elapsed)
+	}
+}
+
+func TestRunCommandCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	start := time.Now()
+	_, err := RunCommand(ctx, "sh", "-c", "sleep 30 | cat")
+
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected cancellation error, got '%v'", err)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("Command not killed on cancellation, took %v", elapsed)
+	}
+
+	if _, err := RunCommand(ctx, "true"); !errors.Is(err, context.Canceled) {
+		t.Errorf("Command run with a done context, got '%v'", err)
+	}
+}
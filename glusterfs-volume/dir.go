@@ -0,0 +1,186 @@
+package glusterfsvolume
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// DirAttrs are the attributes given to a directory created by the plugin,
+// empty fields keep the defaults (root owned, mode 0755).
+type DirAttrs struct {
+	Uid        string `json:",omitempty"`
+	Gid        string `json:",omitempty"`
+	Mode       string `json:",omitempty"`
+	DefaultACL string `json:",omitempty"`
+}
+
+// DirAttrOptions are the volume options setting DirAttrs.
+var DirAttrOptions = map[string]bool{
+	"uid":         true,
+	"gid":         true,
+	"mode":        true,
+	"default-acl": true,
+}
+
+// Set sets the attribute of a DirAttrOptions option.
+func (a *DirAttrs) Set(key, val string) error {
+	switch key {
+	case "uid", "gid":
+		id, err := strconv.Atoi(val)
+		if err != nil || id < 0 {
+			return fmt.Errorf("'%v' option expects a numeric id, got '%v'", key, val)
+		}
+		if key == "uid" {
+			a.Uid = strconv.Itoa(id)
+		} else {
+			a.Gid = strconv.Itoa(id)
+		}
+	case "mode":
+		mode, err := strconv.ParseUint(val, 8, 32)
+		if err != nil || mode > 07777 {
+			return fmt.Errorf("'%v' option expects an octal mode, got '%v'", key, val)
+		}
+		a.Mode = fmt.Sprintf("%04o", mode)
+	case "default-acl":
+		if val == "" || strings.ContainsAny(val, " \t\n") {
+			return fmt.Errorf("'%v' option expects an ACL like 'u:1000:rwx,g::rx', got '%v'", key, val)
+		}
+		a.DefaultACL = val
+	default:
+		return fmt.Errorf("unknown directory attribute '%v'", key)
+	}
+	return nil
+}
+
+func (a DirAttrs) IsZero() bool {
+	return a == DirAttrs{}
+}
+
+// String returns the attributes set, as comma separated key=value pairs.
+func (a DirAttrs) String() string {
+	var attrs []string
+	for _, attr := range [][2]string{
+		{"uid", a.Uid}, {"gid", a.Gid}, {"mode", a.Mode}, {"default-acl", a.DefaultACL}} {
+		if attr[1] != "" {
+			attrs = append(attrs, attr[0]+"="+attr[1])
+		}
+	}
+	return strings.Join(attrs, ",")
+}
+
+// CreateDir creates the mount point directory with attrs. Existing
+// directories are left as is, it returns whether the directory was created.
+func (mv *MountedVolume) CreateDir(ctx context.Context, attrs DirAttrs) (bool, error) {
+	if _, err := os.Lstat(mv.Mountpoint); err == nil || !os.IsNotExist(err) {
+		return false, mv.CreateMountpoint()
+	}
+
+	if err := mv.CreateMountpoint(); err != nil {
+		return false, err
+	}
+	if err := attrs.apply(ctx, mv.Mountpoint); err != nil {
+		// so that the attributes are applied when retrying.
+		os.Remove(mv.Mountpoint)
+		return false, err
+	}
+	return true, nil
+}
+
+func (a DirAttrs) apply(ctx context.Context, dir string) error {
+	if a.Uid != "" || a.Gid != "" {
+		uid, gid := -1, -1
+		if a.Uid != "" {
+			uid, _ = strconv.Atoi(a.Uid)
+		}
+		if a.Gid != "" {
+			gid, _ = strconv.Atoi(a.Gid)
+		}
+		if err := os.Chown(dir, uid, gid); err != nil {
+			return err
+		}
+	}
+	if a.Mode != "" {
+		mode, _ := strconv.ParseUint(a.Mode, 8, 32)
+		if err := os.Chmod(dir, os.FileMode(mode&0777)|unixModeBits(mode)); err != nil {
+			return err
+		}
+	}
+	if a.DefaultACL != "" {
+		output, err := ExecuteCommand(ctx, "setfacl", "-d", "-m", a.DefaultACL, dir)
+		if err != nil {
+			return fmt.Errorf("setfacl command execute failed: %w (%s)", err, output)
+		}
+	}
+	return nil
+}
+
+// unixModeBits converts the setuid, setgid and sticky bits of a unix mode to
+// their os.FileMode flags.
+func unixModeBits(mode uint64) os.FileMode {
+	var m os.FileMode
+	if mode&04000 != 0 {
+		m |= os.ModeSetuid
+	}
+	if mode&02000 != 0 {
+		m |= os.ModeSetgid
+	}
+	if mode&01000 != 0 {
+		m |= os.ModeSticky
+	}
+	return m
+}
+
+// CopyDir copies the content of the directory src into dst, keeping
+// ownership, modes, timestamps, xattrs, ACLs and hard links.
+func CopyDir(ctx context.Context, src, dst string) error {
+	output, err := ExecuteCommand(ctx, "cp", "-a", "--preserve=all", src+"/.", dst)
+	if err != nil {
+		return fmt.Errorf("cp command execute failed: %w (%s)", err, output)
+	}
+	return nil
+}
+
+// tarExtensions are the suffixes of the archives InitDir extracts.
+var tarExtensions = []string{".tar", ".tar.gz", ".tgz", ".tar.bz2", ".tar.xz"}
+
+// CheckInitFrom checks an 'init-from' option, a path relative to the root of
+// the gluster volume.
+func CheckInitFrom(source string) error {
+	clean := filepath.Clean(source)
+	if source == "" || filepath.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, "../") {
+		return fmt.Errorf("invalid 'init-from' option '%v', expected a path in the gluster volume", source)
+	}
+	return nil
+}
+
+// InitDir populates dst with source, a template directory or tar archive
+// stored on the gluster volume mounted on root.
+func InitDir(ctx context.Context, root, source, dst string) error {
+	if err := CheckInitFrom(source); err != nil {
+		return err
+	}
+	path := filepath.Join(root, source)
+	fi, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("template '%v' not found: %v", source, err)
+	}
+	if fi.IsDir() {
+		return CopyDir(ctx, path, dst)
+	}
+
+	for _, ext := range tarExtensions {
+		if strings.HasSuffix(source, ext) {
+			output, err := ExecuteCommand(ctx, "tar", "-x", "--same-owner", "--same-permissions",
+				"--xattrs", "--acls", "-f", path, "-C", dst)
+			if err != nil {
+				return fmt.Errorf("tar command execute failed: %w (%s)", err, output)
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("template '%v' is neither a directory nor a tar archive", source)
+}
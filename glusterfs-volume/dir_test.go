@@ -0,0 +1,129 @@
+package glusterfsvolume
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestDirAttrsSet(t *testing.T) {
+	var a DirAttrs
+	for key, val := range map[string]string{"uid": "01000", "gid": "999", "mode": "2770", "default-acl": "g::rwx"} {
+		if err := a.Set(key, val); err != nil {
+			t.Errorf("Unexpected error setting '%v': %v", key, err)
+		}
+	}
+	if expected := (DirAttrs{Uid: "1000", Gid: "999", Mode: "2770", DefaultACL: "g::rwx"}); a != expected {
+		t.Errorf("Unexpected attributes %#v", a)
+	}
+	if s := a.String(); s != "uid=1000,gid=999,mode=2770,default-acl=g::rwx" {
+		t.Errorf("Unexpected string '%v'", s)
+	}
+
+	for key, val := range map[string]string{"uid": "bob", "gid": "-1", "mode": "0855", "default-acl": "u::rwx g::rx", "owner": "1000"} {
+		if err := a.Set(key, val); err == nil {
+			t.Errorf("'%v=%v' should return error", key, val)
+		}
+	}
+}
+
+func TestCreateDirAppliesAttrsOnce(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "glusterfs-volume-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	var commands [][]string
+	defer func(e func(context.Context, string, ...string) ([]byte, error)) { ExecuteCommand = e }(ExecuteCommand)
+	ExecuteCommand = func(_ context.Context, cmd string, args ...string) ([]byte, error) {
+		commands = append(commands, append([]string{cmd}, args...))
+		return []byte{}, nil
+	}
+
+	mv := MountedVolume{Mountpoint: filepath.Join(tmpDir, "vol")}
+	attrs := DirAttrs{Uid: strconv.Itoa(os.Getuid()), Mode: "0750", DefaultACL: "u::rwx"}
+
+	created, err := mv.CreateDir(context.Background(), attrs)
+	if err != nil || !created {
+		t.Fatalf("Unexpected result %v, '%v'", created, err)
+	}
+	if fi, err := os.Stat(mv.Mountpoint); err != nil || fi.Mode().Perm() != 0750 {
+		t.Errorf("Unexpected mode %v, '%v'", fi.Mode(), err)
+	}
+	if expected := [][]string{{"setfacl", "-d", "-m", "u::rwx", mv.Mountpoint}}; !reflect.DeepEqual(commands, expected) {
+		t.Errorf("Unexpected commands %v", commands)
+	}
+
+	// pre-existing data is left as is.
+	if err := os.Chmod(mv.Mountpoint, 0700); err != nil {
+		t.Fatal(err)
+	}
+	commands = nil
+	created, err = mv.CreateDir(context.Background(), attrs)
+	if err != nil || created {
+		t.Fatalf("Unexpected result %v, '%v'", created, err)
+	}
+	if fi, err := os.Stat(mv.Mountpoint); err != nil || fi.Mode().Perm() != 0700 {
+		t.Errorf("Existing directory mode changed to %v", fi.Mode())
+	}
+	if len(commands) != 0 {
+		t.Errorf("Unexpected commands %v", commands)
+	}
+}
+
+func TestInitDir(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "glusterfs-volume-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	for _, source := range []string{"", "/templates/app", "../app", "templates/../../app"} {
+		if err := CheckInitFrom(source); err == nil {
+			t.Errorf("'init-from=%v' should return error", source)
+		}
+	}
+
+	var commands [][]string
+	defer func(e func(context.Context, string, ...string) ([]byte, error)) { ExecuteCommand = e }(ExecuteCommand)
+	ExecuteCommand = func(_ context.Context, cmd string, args ...string) ([]byte, error) {
+		commands = append(commands, append([]string{cmd}, args...))
+		return []byte{}, nil
+	}
+
+	if err := os.MkdirAll(filepath.Join(tmpDir, "templates", "app"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range []string{"app.tar.gz", "app.zip"} {
+		if err := ioutil.WriteFile(filepath.Join(tmpDir, "templates", file), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dst := filepath.Join(tmpDir, "vol")
+
+	if err := InitDir(context.Background(), tmpDir, "templates/app", dst); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if err := InitDir(context.Background(), tmpDir, "templates/app.tar.gz", dst); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	expected := [][]string{
+		{"cp", "-a", "--preserve=all", filepath.Join(tmpDir, "templates", "app") + "/.", dst},
+		{"tar", "-x", "--same-owner", "--same-permissions", "--xattrs", "--acls",
+			"-f", filepath.Join(tmpDir, "templates", "app.tar.gz"), "-C", dst},
+	}
+	if !reflect.DeepEqual(commands, expected) {
+		t.Errorf("Unexpected commands %v", commands)
+	}
+
+	for _, source := range []string{"templates/app.zip", "templates/missing"} {
+		if err := InitDir(context.Background(), tmpDir, source, dst); err == nil {
+			t.Errorf("'%v' template should return error", source)
+		}
+	}
+}
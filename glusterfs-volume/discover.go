@@ -0,0 +1,135 @@
+package glusterfsvolume
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DiscoverSubdirs returns the names of the directories at the root of a
+// mounted gluster volume that can be adopted as docker volumes. Hidden
+// directories, like the trash and the state store, are skipped.
+func DiscoverSubdirs(root string) ([]string, error) {
+	entries, err := ioutil.ReadDir(root)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, entry := range entries {
+		if entry.IsDir() && validName(entry.Name()) {
+			names = append(names, entry.Name())
+		}
+	}
+	return names, nil
+}
+
+// CheckFilenameFormat checks that file names can be matched against
+// filenameFormat, which must have a single %s verb and no other one.
+func CheckFilenameFormat(filenameFormat string) error {
+	parts := strings.Split(filenameFormat, "%s")
+	if len(parts) != 2 || strings.Contains(parts[0]+parts[1], "%") {
+		return fmt.Errorf("filename format '%v' can not be matched, expected a single %%s verb", filenameFormat)
+	}
+	return nil
+}
+
+// DiscoverFiles returns the docker volume names of the regular files at the
+// root of a mounted gluster volume matching filenameFormat, a format with a
+// single %s verb for the volume name.
+func DiscoverFiles(root, filenameFormat string) ([]string, error) {
+	if err := CheckFilenameFormat(filenameFormat); err != nil {
+		return nil, err
+	}
+	parts := strings.Split(filenameFormat, "%s")
+	prefix, suffix := parts[0], parts[1]
+
+	entries, err := ioutil.ReadDir(root)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, entry := range entries {
+		filename := entry.Name()
+		if !entry.Mode().IsRegular() || len(filename) <= len(prefix)+len(suffix) ||
+			!strings.HasPrefix(filename, prefix) || !strings.HasSuffix(filename, suffix) {
+			continue
+		}
+		name := filename[len(prefix) : len(filename)-len(suffix)]
+		if validName(name) {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+// IsSubdirDiscovered tells whether DiscoverSubdirs would find name on the
+// gluster volume mounted on root.
+func IsSubdirDiscovered(root, name string) (bool, error) {
+	if !validName(name) {
+		return false, nil
+	}
+	fi, err := os.Lstat(filepath.Join(root, name))
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return fi.IsDir(), nil
+}
+
+// IsFileDiscovered tells whether DiscoverFiles would find name on the
+// gluster volume mounted on root.
+func IsFileDiscovered(root, filenameFormat, name string) (bool, error) {
+	if !validName(name) {
+		return false, nil
+	}
+	fi, err := os.Lstat(filepath.Join(root, fmt.Sprintf(filenameFormat, name)))
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return fi.Mode().IsRegular(), nil
+}
+
+// DiscoveryMaxAge is how long listings reuse the names discovered.
+const DiscoveryMaxAge = 30 * time.Second
+
+// Discovery caches the names discovered on a gluster volume, so that
+// listings do not mount it each time. The zero value is ready to use.
+type Discovery struct {
+	mu      sync.Mutex
+	names   []string
+	updated time.Time
+}
+
+// Names returns the names found by discover, which is called again once the
+// names cached are older than maxAge. Errors are not cached.
+func (c *Discovery) Names(maxAge time.Duration, discover func() ([]string, error)) ([]string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.updated.IsZero() && time.Since(c.updated) < maxAge {
+		return c.names, nil
+	}
+	names, err := discover()
+	if err != nil {
+		return nil, err
+	}
+	c.names, c.updated = names, time.Now()
+	return names, nil
+}
+
+// Reset drops the names cached, the next listing discovers them again.
+func (c *Discovery) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.names, c.updated = nil, time.Time{}
+}
@@ -0,0 +1,81 @@
+package glusterfsvolume
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDiscover(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "glusterfs-volume-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	for _, dir := range []string{"app", TrashDir, storeDir, "_binds", "vol-dir.img"} {
+		if err := os.Mkdir(filepath.Join(tmpDir, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, file := range []string{"vol-db.img", "vol-.img", "vol-a b.img", "db.img", "notes"} {
+		if err := ioutil.WriteFile(filepath.Join(tmpDir, file), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if names, err := DiscoverSubdirs(tmpDir); err != nil || !reflect.DeepEqual(names, []string{"app", "vol-dir.img"}) {
+		t.Errorf("Unexpected subdirs %v, '%v'", names, err)
+	}
+	if names, err := DiscoverFiles(tmpDir, "vol-%s.img"); err != nil || !reflect.DeepEqual(names, []string{"db"}) {
+		t.Errorf("Unexpected files %v, '%v'", names, err)
+	}
+	if names, err := DiscoverFiles(tmpDir, "%s.img"); err != nil || !reflect.DeepEqual(names, []string{"db", "vol-", "vol-db"}) {
+		t.Errorf("Unexpected files %v, '%v'", names, err)
+	}
+	for _, format := range []string{"%s-%s.img", "%d.img", "vol.img"} {
+		if _, err := DiscoverFiles(tmpDir, format); err == nil {
+			t.Errorf("Filename format '%v' should return error", format)
+		}
+	}
+
+	for name, expected := range map[string]bool{"app": true, "vol-db.img": false, TrashDir: false, "missing": false} {
+		if found, err := IsSubdirDiscovered(tmpDir, name); err != nil || found != expected {
+			t.Errorf("Unexpected result for subdir '%v': %v, '%v'", name, found, err)
+		}
+	}
+	for name, expected := range map[string]bool{"db": true, "dir": false, "": false, "missing": false} {
+		if found, err := IsFileDiscovered(tmpDir, "vol-%s.img", name); err != nil || found != expected {
+			t.Errorf("Unexpected result for file '%v': %v, '%v'", name, found, err)
+		}
+	}
+}
+
+func TestDiscoveryCache(t *testing.T) {
+	calls := 0
+	discover := func() ([]string, error) {
+		calls++
+		return []string{"app"}, nil
+	}
+
+	var c Discovery
+	for i := 0; i < 2; i++ {
+		if names, err := c.Names(time.Hour, discover); err != nil || !reflect.DeepEqual(names, []string{"app"}) {
+			t.Errorf("Unexpected names %v, '%v'", names, err)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("Names should be cached, discovered %d times", calls)
+	}
+
+	c.Reset()
+	if _, err := c.Names(time.Hour, discover); err != nil || calls != 2 {
+		t.Errorf("Names should be discovered again after Reset, discovered %d times, '%v'", calls, err)
+	}
+	if _, err := c.Names(0, discover); err != nil || calls != 3 {
+		t.Errorf("Names older than maxAge should be discovered again, discovered %d times, '%v'", calls, err)
+	}
+}
@@ -0,0 +1,120 @@
+package glusterfsvolume
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const minRetryDelay = 5 * time.Second
+const maxRetryDelay = 5 * time.Minute
+
+// MountHealth is the last known health of a mount.
+type MountHealth struct {
+	// Error holds the reason why the volume could not be (re)mounted, it is
+	// empty when the volume is healthy.
+	Error     string
+	Failures  int
+	LastCheck time.Time
+	NextRetry time.Time
+}
+
+func (h *MountHealth) Degraded() bool {
+	return h.Error != ""
+}
+
+// Failed records a failed mount and delays the next retry, doubling the
+// delay on each consecutive failure.
+func (h *MountHealth) Failed(err error) {
+	h.Error = err.Error()
+	h.Failures++
+
+	delay := maxRetryDelay
+	if h.Failures < 32 && minRetryDelay<<uint(h.Failures-1) < maxRetryDelay {
+		delay = minRetryDelay << uint(h.Failures-1)
+	}
+	h.NextRetry = time.Now().Add(delay)
+}
+
+func (h *MountHealth) Recovered() {
+	h.Error = ""
+	h.Failures = 0
+	h.NextRetry = time.Time{}
+}
+
+// Status returns the health as reported in docker volume status, nil when
+// healthy.
+func (h *MountHealth) Status() map[string]interface{} {
+	if !h.Degraded() {
+		return nil
+	}
+	return map[string]interface{}{
+		"degraded":  h.Error,
+		"failures":  h.Failures,
+		"nextRetry": h.NextRetry,
+	}
+}
+
+// isStale tells whether a mount point does not answer anymore, as happens
+// when the glusterfs client process died.
+func isStale(err error) bool {
+	return errors.Is(err, syscall.ENOTCONN) || errors.Is(err, syscall.ESTALE)
+}
+
+// ForceUnmount lazily unmounts the mount point, which works on stale mounts.
+func (mv *MountedVolume) ForceUnmount(ctx context.Context) error {
+	output, err := ExecuteCommand(ctx, "umount", "-l", mv.Mountpoint)
+	if err != nil {
+		return fmt.Errorf("umount command execute failed: %w (%s)", err, output)
+	}
+	return nil
+}
+
+// Heal checks a volume that should be mounted and mounts it again with mount
+// when it is stale or missing, unless the last failure is too recent.
+// It returns whether the volume was remounted.
+func (mv *MountedVolume) Heal(ctx context.Context, mount func() error) (bool, error) {
+	now := time.Now()
+	mv.Health.LastCheck = now
+
+	mounted := mv.IsMounted()
+	_, err := os.Stat(mv.Mountpoint)
+	if mounted && err == nil {
+		if mv.Health.Degraded() {
+			logrus.WithField("mountpoint", mv.Mountpoint).Info("mount recovered")
+			mv.Health.Recovered()
+		}
+		return false, nil
+	}
+
+	if mounted && !isStale(err) {
+		mv.Health.Failed(err)
+		return false, err
+	}
+
+	if now.Before(mv.Health.NextRetry) {
+		return false, nil
+	}
+
+	if mounted {
+		logrus.WithField("mountpoint", mv.Mountpoint).Warnf("stale mount, remounting: %v", err)
+		if err := mv.ForceUnmount(ctx); err != nil {
+			mv.Health.Failed(err)
+			return false, err
+		}
+	} else {
+		logrus.WithField("mountpoint", mv.Mountpoint).Warn("missing mount, remounting")
+	}
+
+	if err := mount(); err != nil {
+		mv.Health.Failed(err)
+		return false, err
+	}
+	mv.Health.Recovered()
+	return true, nil
+}
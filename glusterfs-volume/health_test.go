@@ -0,0 +1,75 @@
+package glusterfsvolume
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestMountHealthBackoff(t *testing.T) {
+	h := MountHealth{}
+
+	h.Failed(errors.New("failure"))
+	first := time.Until(h.NextRetry)
+	h.Failed(errors.New("failure"))
+	second := time.Until(h.NextRetry)
+
+	if !h.Degraded() || h.Failures != 2 {
+		t.Errorf("Unexpected health %#v", h)
+	}
+	if second <= first {
+		t.Errorf("Retry delay should grow: %v then %v", first, second)
+	}
+
+	for i := 0; i < 100; i++ {
+		h.Failed(errors.New("failure"))
+	}
+	if time.Until(h.NextRetry) > maxRetryDelay {
+		t.Errorf("Retry delay %v above maximum", time.Until(h.NextRetry))
+	}
+
+	h.Recovered()
+	if h.Degraded() || h.Status() != nil {
+		t.Errorf("Recovered health still degraded %#v", h)
+	}
+}
+
+func TestHealMissingMount(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "glusterfs-volume-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	mv := MountedVolume{Mountpoint: tmpDir}
+
+	mounts := 0
+	failingMount := func() error {
+		mounts++
+		return errors.New("unreachable")
+	}
+
+	if _, err := mv.Heal(context.Background(), failingMount); err == nil {
+		t.Error("Failing mount should return error")
+	}
+	if mounts != 1 || !mv.Health.Degraded() {
+		t.Errorf("Missing mount not remounted: %v mounts, health %#v", mounts, mv.Health)
+	}
+
+	// retry is delayed after a failure.
+	if _, err := mv.Heal(context.Background(), failingMount); err != nil || mounts != 1 {
+		t.Errorf("Mount retried before backoff delay: %v mounts, %v", mounts, err)
+	}
+
+	mv.Health.NextRetry = time.Time{}
+	healed, err := mv.Heal(context.Background(), func() error { return nil })
+	if err != nil || !healed {
+		t.Errorf("Mount not healed: %v, %v", healed, err)
+	}
+	if mv.Health.Degraded() {
+		t.Errorf("Healed mount still degraded %#v", mv.Health)
+	}
+}
@@ -0,0 +1,127 @@
+package glusterfsvolume
+
+import (
+	"sync"
+)
+
+// KeyedRWMutex provides a read-write mutex per key, the zero value is ready
+// to use. Mutexes are freed once nobody holds or waits for them.
+type KeyedRWMutex struct {
+	mu    sync.Mutex
+	locks map[string]*keyedLock
+}
+
+type keyedLock struct {
+	sync.RWMutex
+	refs int
+}
+
+func (km *KeyedRWMutex) acquire(key string) *keyedLock {
+	km.mu.Lock()
+	defer km.mu.Unlock()
+
+	if km.locks == nil {
+		km.locks = map[string]*keyedLock{}
+	}
+	l, ok := km.locks[key]
+	if !ok {
+		l = &keyedLock{}
+		km.locks[key] = l
+	}
+	l.refs++
+	return l
+}
+
+func (km *KeyedRWMutex) release(key string, l *keyedLock) {
+	km.mu.Lock()
+	defer km.mu.Unlock()
+
+	l.refs--
+	if l.refs == 0 {
+		delete(km.locks, key)
+	}
+}
+
+// Lock write locks key, and returns the function unlocking it.
+func (km *KeyedRWMutex) Lock(key string) func() {
+	l := km.acquire(key)
+	l.Lock()
+	return func() {
+		l.Unlock()
+		km.release(key, l)
+	}
+}
+
+// RLock read locks key, and returns the function unlocking it.
+func (km *KeyedRWMutex) RLock(key string) func() {
+	l := km.acquire(key)
+	l.RLock()
+	return func() {
+		l.RUnlock()
+		km.release(key, l)
+	}
+}
+
+// LockWithRLock write locks key and read locks rkey, in key order so that
+// callers locking the same keys crosswise do not deadlock. rkey is ignored
+// if empty or equal to key. It returns the function unlocking both.
+func (km *KeyedRWMutex) LockWithRLock(key, rkey string) func() {
+	if rkey == "" || rkey == key {
+		return km.Lock(key)
+	}
+
+	var unlock, runlock func()
+	if key < rkey {
+		unlock = km.Lock(key)
+		runlock = km.RLock(rkey)
+	} else {
+		runlock = km.RLock(rkey)
+		unlock = km.Lock(key)
+	}
+	return func() {
+		runlock()
+		unlock()
+	}
+}
+
+// CallGroup deduplicates concurrent calls sharing a key: calls made while
+// one is in flight wait for it and get its result. The zero value is ready
+// to use.
+type CallGroup struct {
+	mu    sync.Mutex
+	calls map[string]*call
+}
+
+type call struct {
+	done chan struct{}
+	err  error
+}
+
+// waiting is called with the key of a call about to wait for the one in
+// flight, tests replace it to synchronize with the waiting calls.
+var waiting = func(key string) {}
+
+func (g *CallGroup) Do(key string, fn func() error) error {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = map[string]*call{}
+	}
+	if c, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		waiting(key)
+		<-c.done
+		return c.err
+	}
+	c := &call{done: make(chan struct{})}
+	g.calls[key] = c
+	g.mu.Unlock()
+
+	c.err = fn()
+
+	g.mu.Lock()
+	delete(g.calls, key)
+	g.mu.Unlock()
+	close(c.done)
+
+	return c.err
+}
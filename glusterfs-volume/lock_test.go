@@ -0,0 +1,126 @@
+package glusterfsvolume
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCallGroupDeduplicates(t *testing.T) {
+	var g CallGroup
+	calls := 0
+	release := make(chan struct{})
+	started := make(chan struct{})
+
+	fn := func() error {
+		calls++
+		close(started)
+		<-release
+		return errors.New("failed")
+	}
+
+	waiters := make(chan string, 2)
+	defer func(w func(string)) { waiting = w }(waiting)
+	waiting = func(key string) { waiters <- key }
+
+	errs := make(chan error, 3)
+	go func() { errs <- g.Do("vol", fn) }()
+	<-started
+	for i := 0; i < 2; i++ {
+		go func() { errs <- g.Do("vol", fn) }()
+	}
+	// let the other calls wait for the first one.
+	for i := 0; i < 2; i++ {
+		if key := <-waiters; key != "vol" {
+			t.Errorf("Unexpected call waiting for '%v'", key)
+		}
+	}
+	close(release)
+
+	for i := 0; i < 3; i++ {
+		if err := <-errs; err == nil || err.Error() != "failed" {
+			t.Errorf("Unexpected error '%v'", err)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("Expected 1 call, got %v", calls)
+	}
+
+	if err := g.Do("vol", func() error { return nil }); err != nil {
+		t.Errorf("Completed call should not be shared, got '%v'", err)
+	}
+	if len(waiters) != 0 {
+		t.Errorf("Completed call should not be waited for, got %v waiting", len(waiters))
+	}
+}
+
+func TestKeyedRWMutex(t *testing.T) {
+	var km KeyedRWMutex
+
+	unlockA := km.Lock("a")
+	// other keys are not blocked.
+	km.Lock("b")()
+	km.RLock("b")()
+
+	unlockR1 := km.RLock("c")
+	unlockR2 := km.RLock("c")
+
+	var wg sync.WaitGroup
+	locked := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		unlock := km.Lock("c")
+		close(locked)
+		unlock()
+	}()
+
+	unlockR1()
+	select {
+	case <-locked:
+		t.Error("Write lock acquired while read locked")
+	case <-time.After(10 * time.Millisecond):
+	}
+	unlockR2()
+	wg.Wait()
+	unlockA()
+
+	if len(km.locks) != 0 {
+		t.Errorf("Unused locks not freed: %v", km.locks)
+	}
+}
+
+func TestKeyedRWMutexLockWithRLock(t *testing.T) {
+	var km KeyedRWMutex
+
+	// crosswise lockers must not deadlock.
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			km.LockWithRLock("x", "y")()
+		}()
+		go func() {
+			defer wg.Done()
+			km.LockWithRLock("y", "x")()
+		}()
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Crosswise LockWithRLock deadlocked")
+	}
+
+	km.LockWithRLock("x", "x")()
+	km.LockWithRLock("x", "")()
+	if len(km.locks) != 0 {
+		t.Errorf("Unused locks not freed: %v", km.locks)
+	}
+}
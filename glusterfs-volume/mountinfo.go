@@ -0,0 +1,129 @@
+package glusterfsvolume
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// MountInfoPath is the mount table read by GetMounts.
+var MountInfoPath = "/proc/self/mountinfo"
+
+// MountInfo is an entry of the mount table, see proc(5).
+type MountInfo struct {
+	ID     int
+	Parent int
+	Major  int
+	Minor  int
+
+	Root       string
+	Mountpoint string
+	Options    string
+	FSType     string
+	Source     string
+	// SuperOptions are the options of the filesystem itself.
+	SuperOptions string
+}
+
+// ParseMountInfo parses a mount table in /proc/<pid>/mountinfo format.
+func ParseMountInfo(r io.Reader) ([]MountInfo, error) {
+	var mounts []MountInfo
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+
+		// optional fields end with a "-" separator.
+		fields := strings.Split(line, " ")
+		sep := -1
+		for i := 6; i < len(fields); i++ {
+			if fields[i] == "-" {
+				sep = i
+				break
+			}
+		}
+		if len(fields) < 10 || sep < 0 || len(fields) < sep+4 {
+			return nil, fmt.Errorf("invalid mountinfo line '%v'", line)
+		}
+
+		m := MountInfo{
+			Root:         unescapeMountInfo(fields[3]),
+			Mountpoint:   unescapeMountInfo(fields[4]),
+			Options:      fields[5],
+			FSType:       unescapeMountInfo(fields[sep+1]),
+			Source:       unescapeMountInfo(fields[sep+2]),
+			SuperOptions: fields[sep+3],
+		}
+
+		var err error
+		if m.ID, err = strconv.Atoi(fields[0]); err != nil {
+			return nil, fmt.Errorf("invalid mount ID in '%v': %v", line, err)
+		}
+		if m.Parent, err = strconv.Atoi(fields[1]); err != nil {
+			return nil, fmt.Errorf("invalid parent ID in '%v': %v", line, err)
+		}
+		if _, err := fmt.Sscanf(fields[2], "%d:%d", &m.Major, &m.Minor); err != nil {
+			return nil, fmt.Errorf("invalid device in '%v': %v", line, err)
+		}
+
+		mounts = append(mounts, m)
+	}
+
+	return mounts, scanner.Err()
+}
+
+// unescapeMountInfo decodes the octal escapes (\040 for space...) used in
+// the mount table.
+func unescapeMountInfo(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) {
+			if c, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(c))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// GetMounts returns the mount table of the plugin.
+func GetMounts() ([]MountInfo, error) {
+	f, err := os.Open(MountInfoPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return ParseMountInfo(f)
+}
+
+// FindMount returns the topmost mount on mountpoint, nil if not mounted.
+func FindMount(mountpoint string) (*MountInfo, error) {
+	mounts, err := GetMounts()
+	if err != nil {
+		return nil, err
+	}
+
+	mountpoint = filepath.Clean(mountpoint)
+	var found *MountInfo
+	for i := range mounts {
+		if mounts[i].Mountpoint == mountpoint {
+			found = &mounts[i]
+		}
+	}
+	return found, nil
+}
@@ -0,0 +1,100 @@
+package glusterfsvolume
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testMountInfo = `22 1 0:21 / /proc rw,nosuid,nodev,noexec,relatime shared:12 - proc proc rw
+98 22 0:45 / /mnt/server1,server2/vol\040name rw,relatime shared:50 master:3 - fuse.glusterfs server1,server2:/vol rw,user_id=0,group_id=0,allow_other
+99 98 7:0 / /mnt/block rw,relatime - xfs /dev/loop0 rw,attr2
+100 98 0:45 /subdir /mnt/server1,server2/vol\040name rw,relatime - ext4 /dev/sda1 rw
+`
+
+func TestParseMountInfo(t *testing.T) {
+	mounts, err := ParseMountInfo(strings.NewReader(testMountInfo))
+	if err != nil {
+		t.Fatalf("Unexpected error '%v'", err)
+	}
+	if len(mounts) != 4 {
+		t.Fatalf("Expected 4 mounts, got %v", len(mounts))
+	}
+
+	expected := MountInfo{
+		ID:           98,
+		Parent:       22,
+		Major:        0,
+		Minor:        45,
+		Root:         "/",
+		Mountpoint:   "/mnt/server1,server2/vol name",
+		Options:      "rw,relatime",
+		FSType:       "fuse.glusterfs",
+		Source:       "server1,server2:/vol",
+		SuperOptions: "rw,user_id=0,group_id=0,allow_other",
+	}
+	if !reflect.DeepEqual(mounts[1], expected) {
+		t.Errorf("Parsed mount\n%#v\n expected\n%#v", mounts[1], expected)
+	}
+
+	if _, err := ParseMountInfo(strings.NewReader("22 1 0:21 / /proc rw\n")); err == nil {
+		t.Error("Truncated line should return error")
+	}
+}
+
+func TestUnescapeMountInfo(t *testing.T) {
+	cases := map[string]string{
+		`/mnt/a\040b`:    "/mnt/a b",
+		`/mnt/a\011b`:    "/mnt/a\tb",
+		`/mnt/a\134b`:    `/mnt/a\b`,
+		`/mnt/plain`:     "/mnt/plain",
+		`/mnt/trailing\`: `/mnt/trailing\`,
+	}
+	for escaped, path := range cases {
+		if p := unescapeMountInfo(escaped); p != path {
+			t.Errorf("'%v' unescaped to '%v', expected '%v'", escaped, p, path)
+		}
+	}
+}
+
+func TestIsMountedChecksFSType(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "glusterfs-volume-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	MountInfoPath = filepath.Join(tmpDir, "mountinfo")
+	defer func() { MountInfoPath = "/proc/self/mountinfo" }()
+
+	mountpoint := filepath.Join(tmpDir, "vol name")
+	if err := os.Mkdir(mountpoint, 0755); err != nil {
+		t.Fatal(err)
+	}
+	escaped := strings.Replace(mountpoint, " ", `\040`, -1)
+
+	write := func(fstype string) {
+		line := "98 22 0:45 / " + escaped + " rw - " + fstype + " server1:/vol rw\n"
+		if err := ioutil.WriteFile(MountInfoPath, []byte(line), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	gv := GlusterfsVolume{MountedVolume: MountedVolume{Mountpoint: mountpoint}}
+
+	write("fuse.glusterfs")
+	if !gv.IsMounted() {
+		t.Error("Glusterfs mount with spaces in mount point not found")
+	}
+
+	write("ext4")
+	if gv.IsMounted() {
+		t.Error("Non glusterfs mount should not be seen as mounted gluster volume")
+	}
+	if !gv.MountedVolume.IsMounted() {
+		t.Error("Mount point should be seen as mounted")
+	}
+}
@@ -0,0 +1,280 @@
+package glusterfsvolume
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// MountState is the state of a driver whose gluster mounts are managed by
+// Mounts. It is locked with Lock and Unlock, its other methods are called
+// with it locked.
+type MountState interface {
+	sync.Locker
+	// GlusterVolumes returns the gluster volumes of the state.
+	GlusterVolumes() State
+	// Used tells whether the gluster volume must stay mounted on this host.
+	Used(gvId string) bool
+	// Forget removes the gluster volume from the state and saves it, unless
+	// a docker volume is stored on it, and tells whether it was removed.
+	Forget(gvId string) (bool, error)
+}
+
+// Mounts shares the gluster mounts of a driver between its docker volumes:
+// concurrent mounts of a gluster volume are done once, and it is released
+// once unused. The zero value is ready to use.
+//
+// Locks are taken in this order: the mount locks, the state lock.
+type Mounts struct {
+	// locks are read locked while a gluster volume is mounted or used, and
+	// write locked while it is released or healed.
+	locks KeyedRWMutex
+	// calls deduplicates concurrent mounts of a gluster volume.
+	calls CallGroup
+	// pending counts the uses in progress per gluster volume, which must not
+	// be released meanwhile. It is protected by the state lock.
+	pending map[string]int
+}
+
+// RLock read locks the gluster volume gvId, and returns the function
+// unlocking it.
+func (m *Mounts) RLock(gvId string) func() {
+	return m.locks.RLock(gvId)
+}
+
+// Lock write locks the gluster volume gvId, and returns the function
+// unlocking it.
+func (m *Mounts) Lock(gvId string) func() {
+	return m.locks.Lock(gvId)
+}
+
+// Mount mounts the gluster volume gvId of s and records the outcome in its
+// health. The caller must hold the volume read lock.
+func (m *Mounts) Mount(ctx context.Context, s MountState, gvId string, gv *GlusterfsVolume) error {
+	// only the caller doing the mount gets the server, others keep the one
+	// it records.
+	server := ""
+	err := m.calls.Do(gvId, func() (err error) {
+		server, err = gv.MountServer(ctx)
+		return err
+	})
+
+	s.Lock()
+	defer s.Unlock()
+
+	if gv, ok := s.GlusterVolumes()[gvId]; ok {
+		if err != nil {
+			gv.Health.Failed(err)
+		} else {
+			gv.Health.Recovered()
+			if server != "" {
+				gv.ActiveServer = server
+			}
+		}
+	}
+	return err
+}
+
+// AddPending updates the count of uses in progress of a gluster volume, the
+// caller must hold the state lock.
+func (m *Mounts) AddPending(gvId string, delta int) {
+	if m.pending == nil {
+		m.pending = map[string]int{}
+	}
+	m.pending[gvId] += delta
+	if m.pending[gvId] == 0 {
+		delete(m.pending, gvId)
+	}
+}
+
+// Pending tells whether a gluster volume has uses in progress, the caller
+// must hold the state lock.
+func (m *Mounts) Pending(gvId string) bool {
+	return m.pending[gvId] != 0
+}
+
+// Use calls fn with the gluster volume of conf mounted, read locked and kept
+// meanwhile, and releases it afterwards if unused. The volume is added to s
+// if needed, mounted under root.
+func (m *Mounts) Use(ctx context.Context, s MountState, conf Config, root string, fn func(id string, gv *GlusterfsVolume) error) error {
+	// servers are resolved first, as lookups must not hold the state lock.
+	s.Lock()
+	shared := s.GlusterVolumes().SharedServers()
+	s.Unlock()
+	keys := ResolveServerKeys(conf, shared)
+
+	s.Lock()
+	id, err := s.GlusterVolumes().GetOrCreateResolvedVolume(conf, root, keys)
+	if err == nil {
+		m.AddPending(id, 1)
+	}
+	gv := s.GlusterVolumes()[id]
+	s.Unlock()
+	if err != nil {
+		return err
+	}
+
+	return m.UsePending(ctx, s, id, gv, func() error {
+		return fn(id, gv)
+	})
+}
+
+// UsePending calls fn with the gluster volume gvId of s mounted and read
+// locked, and releases it afterwards if unused. The caller must have added a
+// pending use of the volume, UsePending removes it.
+func (m *Mounts) UsePending(ctx context.Context, s MountState, gvId string, gv *GlusterfsVolume, fn func() error) error {
+	err := func() error {
+		unlock := m.locks.RLock(gvId)
+		defer unlock()
+
+		if err := m.Mount(ctx, s, gvId, gv); err != nil {
+			return err
+		}
+		return fn()
+	}()
+
+	s.Lock()
+	m.AddPending(gvId, -1)
+	s.Unlock()
+
+	if releaseErr := m.Release(ctx, s, gvId); releaseErr != nil {
+		logrus.WithField("volume", gvId).Warnf("Error releasing unused mount: %s", releaseErr)
+	}
+	return err
+}
+
+// Release unmounts the gluster volume gvId of s unless it is used or has
+// uses in progress, and forgets it if no docker volume is stored on it.
+func (m *Mounts) Release(ctx context.Context, s MountState, gvId string) error {
+	unlock := m.locks.Lock(gvId)
+	defer unlock()
+
+	s.Lock()
+	gv, ok := s.GlusterVolumes()[gvId]
+	used := s.Used(gvId) || m.Pending(gvId)
+	s.Unlock()
+	if !ok || used {
+		return nil
+	}
+
+	if err := gv.Unmount(ctx); err != nil {
+		return err
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	if m.Pending(gvId) {
+		return nil
+	}
+	forgotten, err := s.Forget(gvId)
+	if forgotten {
+		if err := gv.DeleteMountpoint(); err != nil {
+			logrus.Warnf("Error deleting Glusterfs mount point: %s", err)
+		}
+	}
+	return err
+}
+
+// HealAll heals the gluster volumes of s used on this host, see Heal.
+func (m *Mounts) HealAll(ctx context.Context, s MountState, healed func(ctx context.Context, gvId string, remounted bool)) {
+	s.Lock()
+	var ids []string
+	for id := range s.GlusterVolumes() {
+		if s.Used(id) {
+			ids = append(ids, id)
+		}
+	}
+	s.Unlock()
+
+	for _, id := range ids {
+		m.Heal(ctx, s, id, healed)
+	}
+}
+
+// Heal remounts the gluster volume gvId of s if it is used, and stale or
+// missing. Unless the volume is degraded, healed is then called with the
+// volume write locked, and whether it was remounted.
+func (m *Mounts) Heal(ctx context.Context, s MountState, gvId string, healed func(ctx context.Context, gvId string, remounted bool)) {
+	unlock := m.locks.Lock(gvId)
+	defer unlock()
+
+	s.Lock()
+	gv, ok := s.GlusterVolumes()[gvId]
+	var mv MountedVolume
+	if ok {
+		mv = gv.MountedVolume
+	}
+	used := s.Used(gvId)
+	s.Unlock()
+	if !ok || !used {
+		return
+	}
+
+	// heal a copy, the health is read under the state lock.
+	server := ""
+	remounted, err := mv.Heal(ctx, func() (err error) {
+		server, err = gv.MountServer(ctx)
+		return err
+	})
+	if err != nil {
+		logrus.WithField("volume", gvId).Errorf(
+			"Error remounting, next retry at %v: %s", mv.Health.NextRetry, err)
+	} else if remounted {
+		logrus.WithField("volume", gvId).Warn("Gluster volume remounted")
+	}
+
+	s.Lock()
+	if gv, ok := s.GlusterVolumes()[gvId]; ok {
+		gv.Health = mv.Health
+		if remounted {
+			gv.ActiveServer = server
+		}
+	}
+	s.Unlock()
+
+	if !mv.Health.Degraded() {
+		healed(ctx, gvId, remounted)
+	}
+}
+
+// PurgeTrashes purges the trashes of the gluster volumes of trashes, mounted
+// under root meanwhile if not used anymore, and returns the records whose
+// data is all purged. resolve and retention are the ResolveServers and
+// trash-retention options of the driver.
+func (m *Mounts) PurgeTrashes(ctx context.Context, s MountState, root string, trashes Trashes, resolve bool, retention time.Duration) Trashes {
+	purged := Trashes{}
+	for id, tv := range trashes {
+		if err := m.Use(ctx, s, tv.Config(resolve), root, func(_ string, gv *GlusterfsVolume) error {
+			n, err := PurgeTrash(gv.Mountpoint, retention)
+			if n != 0 {
+				logrus.WithField("volume", id).Infof("Purged %d entries from the trash", n)
+			}
+			return err
+		}); err != nil {
+			logrus.WithField("volume", id).Warnf("Error purging trash: %s", err)
+			continue
+		}
+		if time.Since(tv.Trashed) >= retention {
+			purged[id] = tv
+		}
+	}
+	return purged
+}
+
+// Repeat calls fn with ctx every interval, until ctx is done.
+func Repeat(ctx context.Context, interval time.Duration, fn func(ctx context.Context)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			fn(ctx)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package glusterfsvolume
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+type testMountState struct {
+	sync.Mutex
+	volumes   State
+	used      map[string]bool
+	forgotten []string
+}
+
+func (s *testMountState) GlusterVolumes() State { return s.volumes }
+func (s *testMountState) Used(gvId string) bool { return s.used[gvId] }
+
+func (s *testMountState) Forget(gvId string) (bool, error) {
+	delete(s.volumes, gvId)
+	s.forgotten = append(s.forgotten, gvId)
+	return true, nil
+}
+
+func TestMountsUse(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "mounts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	MountInfoPath = filepath.Join(tmpDir, "mountinfo")
+	defer func() { MountInfoPath = "/proc/self/mountinfo" }()
+	if err := ioutil.WriteFile(MountInfoPath, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var commands []string
+	defer func(e func(context.Context, string, ...string) ([]byte, error)) { ExecuteCommand = e }(ExecuteCommand)
+	ExecuteCommand = func(_ context.Context, cmd string, args ...string) ([]byte, error) {
+		commands = append(commands, cmd)
+		switch cmd {
+		case "mount":
+			line := "98 22 0:45 / " + args[3] + " rw - fuse.glusterfs " + args[2] + " rw\n"
+			return nil, ioutil.WriteFile(MountInfoPath, []byte(line), 0644)
+		case "umount":
+			return nil, ioutil.WriteFile(MountInfoPath, nil, 0644)
+		}
+		return nil, nil
+	}
+
+	s := &testMountState{volumes: State{}, used: map[string]bool{}}
+	conf := Config{Servers: "server", VolumeName: "volume"}
+	var m Mounts
+
+	var usedId string
+	if err := m.Use(context.Background(), s, conf, tmpDir, func(id string, gv *GlusterfsVolume) error {
+		if !gv.IsMounted() {
+			t.Error("volume not mounted during use")
+		}
+		usedId = id
+		s.used[id] = true
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(commands) != 1 || commands[0] != "mount" {
+		t.Errorf("used volume must stay mounted, got commands %v", commands)
+	}
+	if m.Pending(usedId) {
+		t.Error("use still pending after Use")
+	}
+
+	s.used[usedId] = false
+	if err := m.Release(context.Background(), s, usedId); err != nil {
+		t.Fatal(err)
+	}
+	if len(commands) != 2 || commands[1] != "umount" {
+		t.Errorf("unused volume must be unmounted, got commands %v", commands)
+	}
+	if len(s.forgotten) != 1 || s.forgotten[0] != usedId {
+		t.Errorf("unused volume must be forgotten, got %v", s.forgotten)
+	}
+}
+
+func TestMountsReleasePending(t *testing.T) {
+	defer func(e func(context.Context, string, ...string) ([]byte, error)) { ExecuteCommand = e }(ExecuteCommand)
+	ExecuteCommand = func(_ context.Context, cmd string, args ...string) ([]byte, error) {
+		t.Errorf("unexpected command %v %v", cmd, args)
+		return nil, nil
+	}
+
+	s := &testMountState{volumes: State{"id": &GlusterfsVolume{}}}
+	var m Mounts
+	m.AddPending("id", 1)
+
+	if err := m.Release(context.Background(), s, "id"); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.forgotten) != 0 {
+		t.Errorf("volume with a pending use must be kept, got %v forgotten", s.forgotten)
+	}
+}
@@ -0,0 +1,199 @@
+package glusterfsvolume
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type OptionType int
+
+const (
+	// FlagOption takes no value, e.g. 'acl'.
+	FlagOption OptionType = iota
+	IntOption
+	EnumOption
+	// DurationOption is a number of seconds, as used by fuse timeouts.
+	DurationOption
+	StringOption
+)
+
+// OptionSpec describes a mount.glusterfs option.
+type OptionSpec struct {
+	Type OptionType
+	// Values are the allowed values of enum options.
+	Values []string
+	// Unsupported tells why the option can not be set, empty if it can.
+	Unsupported string
+}
+
+var yesNo = []string{"yes", "no"}
+
+// onOff are the values mount.glusterfs accepts for root squashing.
+var onOff = []string{"yes", "no", "on", "off", "enable", "disable", "true", "false"}
+
+// MountOptions are the options accepted for gluster mounts, see
+// mount.glusterfs(8).
+var MountOptions = map[string]OptionSpec{
+	"acl":               {Type: FlagOption},
+	"selinux":           {Type: FlagOption},
+	"worm":              {Type: FlagOption},
+	"ro":                {Type: FlagOption},
+	"noatime":           {Type: FlagOption},
+	"nodev":             {Type: FlagOption},
+	"nosuid":            {Type: FlagOption},
+	"noexec":            {Type: FlagOption},
+	"_netdev":           {Type: FlagOption},
+	"resolve-gids":      {Type: FlagOption},
+	"fopen-keep-cache":  {Type: FlagOption},
+	"enable-ino32":      {Type: FlagOption},
+	"aux-gfid-mount":    {Type: FlagOption},
+	"capability":        {Type: FlagOption},
+	"thin-client":       {Type: FlagOption},
+	"mem-accounting":    {Type: FlagOption},
+	"volfile-check":     {Type: FlagOption},
+	"localtime-logging": {Type: FlagOption},
+	"global-threading":  {Type: FlagOption},
+
+	"log-level": {Type: EnumOption,
+		Values: []string{"CRITICAL", "ERROR", "WARNING", "INFO", "DEBUG", "TRACE", "NONE"}},
+	"transport":                   {Type: EnumOption, Values: []string{"tcp", "rdma", "unix"}},
+	"direct-io-mode":              {Type: EnumOption, Values: []string{"enable", "disable", "auto"}},
+	"use-readdirp":                {Type: EnumOption, Values: yesNo},
+	"auto-invalidation":           {Type: EnumOption, Values: yesNo},
+	"kernel-writeback-cache":      {Type: EnumOption, Values: yesNo},
+	"fuse-flush-handle-interrupt": {Type: EnumOption, Values: yesNo},
+	"no-root-squash":              {Type: EnumOption, Values: onOff},
+	"root-squash":                 {Type: EnumOption, Values: onOff},
+
+	"attribute-timeout": {Type: DurationOption},
+	"entry-timeout":     {Type: DurationOption},
+	"negative-timeout":  {Type: DurationOption},
+	"gid-timeout":       {Type: DurationOption},
+
+	"background-qlen":             {Type: IntOption},
+	"congestion-threshold":        {Type: IntOption},
+	"reader-thread-count":         {Type: IntOption},
+	"lru-limit":                   {Type: IntOption},
+	"invalidate-limit":            {Type: IntOption},
+	"attr-times-granularity":      {Type: IntOption},
+	"volfile-max-fetch-attempts":  {Type: IntOption},
+	"fetch-attempts":              {Type: IntOption},
+	"fuse-dev-eperm-ratelimit-ns": {Type: IntOption},
+
+	"xlator-option":  {Type: StringOption},
+	"fuse-mountopts": {Type: StringOption},
+	// oom-score-adj may be negative, it is left to mount.glusterfs to check.
+	"oom-score-adj": {Type: StringOption},
+	"process-name":  {Type: StringOption},
+	"dump-fuse":     {Type: StringOption},
+
+	"backup-volfile-server":    {Unsupported: "list backup servers in 'servers' instead"},
+	"backup-volfile-servers":   {Unsupported: "list backup servers in 'servers' instead"},
+	"volfile-server":           {Unsupported: "set servers in 'servers' instead"},
+	"volfile-server-port":      {Unsupported: "set ports in 'servers' instead"},
+	"server-port":              {Unsupported: "set ports in 'servers' instead"},
+	"volfile-server-transport": {Unsupported: "set 'transport' instead"},
+	"volume-id":                {Unsupported: "set 'volume-name' instead"},
+	"subdir-mount":             {Unsupported: "sub directories are managed by the plugin"},
+	"log-file":                 {Unsupported: "logs are redirected to managed plugin stdout"},
+}
+
+func CheckOption(key, val string) error {
+	switch key {
+	case "servers":
+		fallthrough
+	case "volume-name":
+		return fmt.Errorf("'%v' option not supported in options", key)
+	}
+
+	spec, ok := MountOptions[key]
+	if !ok {
+		if suggestion := closestOption(key); suggestion != "" {
+			return fmt.Errorf("unknown option '%v', did you mean '%v'?", key, suggestion)
+		}
+		return fmt.Errorf("unknown option '%v'", key)
+	}
+	if spec.Unsupported != "" {
+		return fmt.Errorf("'%v' option not supported, %v", key, spec.Unsupported)
+	}
+
+	switch spec.Type {
+	case FlagOption:
+		if val != "" {
+			return fmt.Errorf("'%v' option takes no value, got '%v'", key, val)
+		}
+	case IntOption:
+		if n, err := strconv.Atoi(val); err != nil || n < 0 {
+			return fmt.Errorf("'%v' option expects a positive integer, got '%v'", key, val)
+		}
+	case EnumOption:
+		for _, v := range spec.Values {
+			if val == v {
+				return nil
+			}
+		}
+		return fmt.Errorf("'%v' option expects one of %v, got '%v'",
+			key, strings.Join(spec.Values, ", "), val)
+	case DurationOption:
+		if f, err := strconv.ParseFloat(val, 64); err != nil || f < 0 {
+			return fmt.Errorf("'%v' option expects a number of seconds, got '%v'", key, val)
+		}
+	case StringOption:
+		if val == "" {
+			return fmt.Errorf("'%v' option expects a value", key)
+		}
+	}
+	return nil
+}
+
+// closestOption returns the supported option nearest to a mistyped one, ""
+// if none is close enough.
+func closestOption(key string) string {
+	var names []string
+	for name, spec := range MountOptions {
+		if spec.Unsupported == "" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	best, bestDistance := "", 3
+	for _, name := range names {
+		if d := editDistance(key, name); d < bestDistance {
+			best, bestDistance = name, d
+		}
+	}
+	return best
+}
+
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
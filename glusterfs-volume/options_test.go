@@ -0,0 +1,103 @@
+package glusterfsvolume
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheckOption(t *testing.T) {
+	valid := map[string]string{
+		"acl":               "",
+		"log-level":         "INFO",
+		"attribute-timeout": "0.5",
+		"background-qlen":   "64",
+		"use-readdirp":      "no",
+		"xlator-option":     "*dht.lookup-optimize=on",
+
+		"enable-ino32":               "",
+		"aux-gfid-mount":             "",
+		"volfile-max-fetch-attempts": "3",
+		"fuse-mountopts":             "default_permissions",
+		"no-root-squash":             "yes",
+		"oom-score-adj":              "-1000",
+	}
+	for key, val := range valid {
+		if err := CheckOption(key, val); err != nil {
+			t.Errorf("Option %v=%v should be valid, got '%v'", key, val, err)
+		}
+	}
+
+	invalid := []struct {
+		key, val string
+		message  string
+	}{
+		{"acl", "yes", "takes no value"},
+		{"log-level", "info", "expects one of CRITICAL, ERROR"},
+		{"attribute-timeout", "1s", "number of seconds"},
+		{"background-qlen", "-1", "positive integer"},
+		{"xlator-option", "", "expects a value"},
+		{"log-levle", "INFO", "did you mean 'log-level'?"},
+		{"whatever", "", "unknown option 'whatever'"},
+		{"log-file", "/tmp/log", "not supported"},
+		{"subdir-mount", "/app", "not supported"},
+		{"no-root-squash", "maybe", "expects one of yes, no"},
+		{"servers", "server1", "not supported in options"},
+	}
+	for _, c := range invalid {
+		err := CheckOption(c.key, c.val)
+		if err == nil {
+			t.Errorf("Option %v=%v should return error", c.key, c.val)
+		} else if !strings.Contains(err.Error(), c.message) {
+			t.Errorf("Option %v=%v: unexpected error '%v'", c.key, c.val, err)
+		}
+	}
+}
+
+func TestConfigOverride(t *testing.T) {
+	defaults := Config{
+		Options:       map[string]string{"acl": "", "log-level": "ERROR", "entry-timeout": "1"},
+		LockedOptions: map[string]bool{"entry-timeout": true},
+	}
+
+	conf := defaults.Copy()
+	for key, val := range map[string]string{"log-level": "DEBUG", "acl": "false", "ro": ""} {
+		if err := conf.Override(key, val); err != nil {
+			t.Errorf("Unexpected error overriding '%v': %v", key, err)
+		}
+	}
+	if options := FormatOptions(conf.Options); options != "entry-timeout=1,log-level=DEBUG,ro" {
+		t.Errorf("Unexpected effective options '%v'", options)
+	}
+	if options := FormatOptions(defaults.Options); options != "acl,entry-timeout=1,log-level=ERROR" {
+		t.Errorf("Defaults modified: '%v'", options)
+	}
+
+	if err := conf.Override("entry-timeout", "2"); err == nil || !strings.Contains(err.Error(), "locked") {
+		t.Errorf("Overriding locked option should return error, got '%v'", err)
+	}
+	if err := conf.Override("log-level", "verbose"); err == nil {
+		t.Error("Invalid override should return error")
+	}
+
+	if _, err := ParseLockedOptions("acl log-levle"); err == nil {
+		t.Error("Unknown locked option should return error")
+	}
+}
+
+func TestStoredOptionsOutsideRegistry(t *testing.T) {
+	// volumes mounted by previous versions may use options that are not
+	// accepted anymore, they must still be shared as they are.
+	s := State{"server1/vol@old": &GlusterfsVolume{
+		Servers:    "server1",
+		VolumeName: "vol",
+		Options:    map[string]string{"some-old-option": "1"},
+	}}
+
+	id, err := s.GetOrCreateVolume(Config{Servers: "server1", VolumeName: "vol", Options: map[string]string{"enable-ino32": ""}}, "/root")
+	if err != nil {
+		t.Fatalf("Unexpected error '%v'", err)
+	}
+	if id == "server1/vol@old" || s["server1/vol@old"].Options["some-old-option"] != "1" {
+		t.Errorf("Unexpected ID '%v', volumes %v", id, s)
+	}
+}
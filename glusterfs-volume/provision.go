@@ -0,0 +1,155 @@
+package glusterfsvolume
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// Provisioning describes how missing gluster volumes are created.
+type Provisioning struct {
+	// Bricks are the host:/directory brick roots, each volume gets a brick
+	// named after it in each of them.
+	Bricks  []string
+	Replica int
+	Arbiter int
+	// OnRemove is what happens to a provisioned volume when its docker
+	// volume is removed: "keep", "stop" or "delete".
+	OnRemove string
+}
+
+// ProvisioningOptions are the plugin options configuring Provisioning.
+var ProvisioningOptions = []string{"provision-bricks", "provision-replica", "provision-arbiter", "provision-remove"}
+
+// ParseProvisioning parses the provisioning options, it returns nil when
+// provisioning is not enabled.
+func ParseProvisioning(options map[string]string) (*Provisioning, error) {
+	bricks, ok := options["provision-bricks"]
+	if !ok {
+		for _, key := range ProvisioningOptions {
+			if _, ok := options[key]; ok {
+				return nil, fmt.Errorf("'%v' option requires 'provision-bricks'", key)
+			}
+		}
+		return nil, nil
+	}
+
+	p := &Provisioning{Replica: 1, OnRemove: "keep"}
+	for _, brick := range strings.Split(bricks, ",") {
+		i := strings.LastIndex(brick, ":/")
+		if i <= 0 {
+			return nil, fmt.Errorf("invalid brick '%v', expected host:/directory", brick)
+		}
+		p.Bricks = append(p.Bricks, brick)
+	}
+
+	var err error
+	if val, ok := options["provision-replica"]; ok {
+		if p.Replica, err = strconv.Atoi(val); err != nil || p.Replica < 1 {
+			return nil, fmt.Errorf("invalid 'provision-replica' option '%v'", val)
+		}
+	}
+	if val, ok := options["provision-arbiter"]; ok {
+		if p.Arbiter, err = strconv.Atoi(val); err != nil || p.Arbiter < 0 || p.Arbiter > 1 {
+			return nil, fmt.Errorf("invalid 'provision-arbiter' option '%v', expected 0 or 1", val)
+		}
+	}
+	if p.Arbiter == 1 && p.Replica != 3 {
+		return nil, errors.New("'provision-arbiter=1' requires 'provision-replica=3'")
+	}
+	if len(p.Bricks)%p.Replica != 0 {
+		return nil, fmt.Errorf("%v bricks can not be split into replica sets of %v", len(p.Bricks), p.Replica)
+	}
+	if val, ok := options["provision-remove"]; ok {
+		switch val {
+		case "keep", "stop", "delete":
+			p.OnRemove = val
+		default:
+			return nil, fmt.Errorf("invalid 'provision-remove' option '%v', expected keep, stop or delete", val)
+		}
+	}
+	return p, nil
+}
+
+// createArgs returns the arguments of gluster volume create for a volume.
+func (p *Provisioning) createArgs(volumeName string) []string {
+	args := []string{"volume", "create", volumeName}
+	if p.Replica > 1 {
+		args = append(args, "replica", strconv.Itoa(p.Replica))
+		if p.Arbiter > 0 {
+			args = append(args, "arbiter", strconv.Itoa(p.Arbiter))
+		}
+	}
+	for _, brick := range p.Bricks {
+		args = append(args, path.Join(brick, volumeName))
+	}
+	return args
+}
+
+// Exists tells whether the volume exists on its servers.
+func (gv *GlusterfsVolume) Exists(ctx context.Context) (bool, error) {
+	output, err := gv.glusterCommand(ctx, "volume", "info", gv.VolumeName, "--xml")
+	if err != nil {
+		if strings.Contains(err.Error(), "does not exist") {
+			return false, nil
+		}
+		return false, err
+	}
+
+	var result struct {
+		OpRet    int    `xml:"opRet"`
+		OpErrstr string `xml:"opErrstr"`
+		Count    int    `xml:"volInfo>volumes>count"`
+	}
+	if err := xml.Unmarshal(output, &result); err != nil {
+		return false, fmt.Errorf("error parsing volume info: %v", err)
+	}
+	if result.OpRet != 0 {
+		if strings.Contains(result.OpErrstr, "does not exist") {
+			return false, nil
+		}
+		return false, fmt.Errorf("volume info failed: %v", result.OpErrstr)
+	}
+	return result.Count > 0, nil
+}
+
+// Provision creates and starts the volume with the bricks of p. The volume
+// is deleted if it can not be started.
+func (gv *GlusterfsVolume) Provision(ctx context.Context, p *Provisioning) error {
+	if _, err := gv.glusterCommandRetried(ctx, func(output string) bool {
+		return strings.Contains(output, "already exists")
+	}, p.createArgs(gv.VolumeName)...); err != nil {
+		return err
+	}
+
+	if _, err := gv.glusterCommandRetried(ctx, func(output string) bool {
+		return strings.Contains(output, "already started")
+	}, "volume", "start", gv.VolumeName); err != nil {
+		// so that a later creation does not find it created but stopped.
+		if _, deleteErr := gv.glusterCommand(ctx, "volume", "delete", gv.VolumeName); deleteErr != nil {
+			return fmt.Errorf("%w, deleting the volume failed: %v", err, deleteErr)
+		}
+		return err
+	}
+	return nil
+}
+
+// Deprovision applies the removal policy of p to the volume, brick
+// directories are left on the servers.
+func (gv *GlusterfsVolume) Deprovision(ctx context.Context, p *Provisioning) error {
+	if p.OnRemove == "keep" {
+		return nil
+	}
+	if _, err := gv.glusterCommand(ctx, "volume", "stop", gv.VolumeName); err != nil {
+		return err
+	}
+	if p.OnRemove == "stop" {
+		return nil
+	}
+	_, err := gv.glusterCommand(ctx, "volume", "delete", gv.VolumeName)
+	return err
+}
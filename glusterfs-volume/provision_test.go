@@ -0,0 +1,138 @@
+package glusterfsvolume
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseProvisioning(t *testing.T) {
+	p, err := ParseProvisioning(map[string]string{
+		"provision-bricks":  "server1:/bricks,server2:/bricks,server3:/arbiter",
+		"provision-replica": "3",
+		"provision-arbiter": "1",
+		"provision-remove":  "delete",
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error '%v'", err)
+	}
+	expected := []string{"volume", "create", "vol", "replica", "3", "arbiter", "1",
+		"server1:/bricks/vol", "server2:/bricks/vol", "server3:/arbiter/vol"}
+	if args := p.createArgs("vol"); !reflect.DeepEqual(args, expected) || p.OnRemove != "delete" {
+		t.Errorf("Unexpected provisioning %#v, create args %v", p, args)
+	}
+
+	if p, err := ParseProvisioning(map[string]string{"acl": ""}); p != nil || err != nil {
+		t.Errorf("Unexpected provisioning %#v ('%v')", p, err)
+	}
+
+	for _, options := range []map[string]string{
+		{"provision-replica": "3"},
+		{"provision-bricks": "/bricks"},
+		{"provision-bricks": "server1:/bricks,server2:/bricks", "provision-replica": "3"},
+		{"provision-bricks": "server1:/b,server2:/b", "provision-replica": "2", "provision-arbiter": "1"},
+		{"provision-bricks": "server1:/bricks", "provision-remove": "purge"},
+	} {
+		if _, err := ParseProvisioning(options); err == nil {
+			t.Errorf("%v should return error", options)
+		}
+	}
+}
+
+func TestExists(t *testing.T) {
+	defer func(e func(context.Context, string, ...string) ([]byte, error)) { ExecuteCommand = e }(ExecuteCommand)
+
+	gv := GlusterfsVolume{Servers: "server1", VolumeName: "vol"}
+	cases := []struct {
+		output string
+		err    error
+		exists bool
+	}{
+		{"<cliOutput><opRet>0</opRet><volInfo><volumes><count>1</count></volumes></volInfo></cliOutput>", nil, true},
+		{"Volume vol does not exist", errors.New("exit status 1"), false},
+		{"<cliOutput><opRet>-1</opRet><opErrstr>Volume vol does not exist</opErrstr></cliOutput>", nil, false},
+	}
+	for _, c := range cases {
+		ExecuteCommand = func(_ context.Context, cmd string, args ...string) ([]byte, error) {
+			return []byte(c.output), c.err
+		}
+		if exists, err := gv.Exists(context.Background()); err != nil || exists != c.exists {
+			t.Errorf("'%v' gave %v ('%v'), expected %v", c.output, exists, err, c.exists)
+		}
+	}
+
+	ExecuteCommand = func(_ context.Context, cmd string, args ...string) ([]byte, error) {
+		return []byte("Connection failed. Please check if gluster daemon is operational."), errors.New("exit status 1")
+	}
+	if _, err := gv.Exists(context.Background()); err == nil {
+		t.Error("Unreachable servers should return error")
+	}
+}
+
+func TestProvision(t *testing.T) {
+	defer func(e func(context.Context, string, ...string) ([]byte, error)) { ExecuteCommand = e }(ExecuteCommand)
+
+	gv := GlusterfsVolume{Servers: "server1,server2", VolumeName: "vol"}
+	p := &Provisioning{Bricks: []string{"server1:/bricks"}, Replica: 1}
+	cases := []struct {
+		name string
+		// fail returns the output and error of the command run on server.
+		fail     func(server, command string) (string, error)
+		err      bool
+		commands []string
+	}{
+		{
+			"create retried after a timeout",
+			func(server, command string) (string, error) {
+				if command != "create" {
+					return "", nil
+				}
+				if server == "server1" {
+					return "", context.DeadlineExceeded
+				}
+				return "volume create: vol: failed: Volume vol already exists", errors.New("exit status 1")
+			},
+			false,
+			[]string{"server1 create", "server2 create", "server1 start"},
+		},
+		{
+			"volume created meanwhile",
+			func(server, command string) (string, error) {
+				if command == "create" {
+					return "volume create: vol: failed: Volume vol already exists", errors.New("exit status 1")
+				}
+				return "", nil
+			},
+			true,
+			[]string{"server1 create"},
+		},
+		{
+			"start failing",
+			func(server, command string) (string, error) {
+				if command == "start" {
+					return "volume start: vol: failed: Commit failed", errors.New("exit status 1")
+				}
+				return "", nil
+			},
+			true,
+			[]string{"server1 create", "server1 start", "server2 start", "server1 delete"},
+		},
+	}
+	for _, c := range cases {
+		var commands []string
+		ExecuteCommand = func(_ context.Context, cmd string, args ...string) ([]byte, error) {
+			server := strings.TrimPrefix(args[1], "--remote-host=")
+			commands = append(commands, server+" "+args[3])
+			output, err := c.fail(server, args[3])
+			return []byte(output), err
+		}
+		if err := gv.Provision(context.Background(), p); (err != nil) != c.err {
+			t.Errorf("%v: unexpected error '%v'", c.name, err)
+		}
+		if !reflect.DeepEqual(commands, c.commands) {
+			t.Errorf("%v: unexpected commands %v", c.name, commands)
+		}
+	}
+}
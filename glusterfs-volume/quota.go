@@ -0,0 +1,182 @@
+package glusterfsvolume
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Quota is the usage of a directory quota, in bytes.
+type Quota struct {
+	Path      string `xml:"path"`
+	Limit     uint64 `xml:"hard_limit"`
+	Used      uint64 `xml:"used_space"`
+	Available uint64 `xml:"avail_space"`
+}
+
+var sizePattern = regexp.MustCompile(`^([0-9]+)([kmgtp]?)b?$`)
+
+// ParseQuotaSize checks a quota size like 10G or 512MB, and returns it in
+// the format of the gluster CLI.
+func ParseQuotaSize(size string) (string, error) {
+	m := sizePattern.FindStringSubmatch(strings.ToLower(strings.TrimSpace(size)))
+	if m == nil || strings.Trim(m[1], "0") == "" {
+		return "", fmt.Errorf("invalid size '%v', expected a number of bytes with an optional K, M, G, T or P unit", size)
+	}
+	if m[2] == "" {
+		return m[1], nil
+	}
+	return m[1] + strings.ToUpper(m[2]) + "B", nil
+}
+
+// SetQuota sets the quota of a directory of the volume, quota must be
+// enabled on the volume.
+func (gv *GlusterfsVolume) SetQuota(ctx context.Context, dir, size string) error {
+	_, err := gv.glusterCommand(ctx, "volume", "quota", gv.VolumeName, "limit-usage", "/"+dir, size)
+	return err
+}
+
+// GetQuota returns the quota usage of a directory of the volume.
+func (gv *GlusterfsVolume) GetQuota(ctx context.Context, dir string) (*Quota, error) {
+	output, err := gv.glusterCommand(ctx, "volume", "quota", gv.VolumeName, "list", "/"+dir, "--xml")
+	if err != nil {
+		return nil, err
+	}
+
+	var result struct {
+		OpRet    int     `xml:"opRet"`
+		OpErrstr string  `xml:"opErrstr"`
+		Limits   []Quota `xml:"volQuota>limit"`
+	}
+	if err := xml.Unmarshal(output, &result); err != nil {
+		return nil, fmt.Errorf("error parsing quota list: %v", err)
+	}
+	if result.OpRet != 0 {
+		return nil, fmt.Errorf("quota list failed: %v", result.OpErrstr)
+	}
+	for _, quota := range result.Limits {
+		if quota.Path == "/"+dir {
+			return &quota, nil
+		}
+	}
+	return nil, fmt.Errorf("no quota set on '/%v'", dir)
+}
+
+// Defaults of QuotaCache.
+const (
+	DefaultQuotaTTL  = 30 * time.Second
+	DefaultQuotaWait = 5 * time.Second
+)
+
+// QuotaCache reuses recently read quota usages, so that inspecting volumes
+// does not run a gluster command each time. The zero value is ready to use.
+type QuotaCache struct {
+	// TTL is how long a read quota is reused, DefaultQuotaTTL if 0.
+	TTL time.Duration
+	// Wait bounds how long Get waits for a read, which goes on and is
+	// cached meanwhile, DefaultQuotaWait if 0.
+	Wait time.Duration
+
+	mu    sync.Mutex
+	reads map[string]*quotaRead
+}
+
+type quotaRead struct {
+	done  chan struct{}
+	quota *Quota
+	err   error
+	at    time.Time
+}
+
+// expired tells whether a completed read is older than ttl.
+func (r *quotaRead) expired(ttl time.Duration) bool {
+	select {
+	case <-r.done:
+		return time.Since(r.at) > ttl
+	default:
+		return false
+	}
+}
+
+// Get returns the quota usage of a directory of gv, read at most TTL ago.
+func (qc *QuotaCache) Get(ctx context.Context, gv GlusterfsVolume, dir string) (*Quota, error) {
+	ttl, wait := qc.TTL, qc.Wait
+	if ttl == 0 {
+		ttl = DefaultQuotaTTL
+	}
+	if wait == 0 {
+		wait = DefaultQuotaWait
+	}
+	key := gv.Servers + "/" + gv.VolumeName + "/" + dir
+
+	qc.mu.Lock()
+	if qc.reads == nil {
+		qc.reads = map[string]*quotaRead{}
+	}
+	r, ok := qc.reads[key]
+	if !ok || r.expired(ttl) {
+		for k, old := range qc.reads {
+			if old.expired(ttl) {
+				delete(qc.reads, k)
+			}
+		}
+		r = &quotaRead{done: make(chan struct{})}
+		qc.reads[key] = r
+		go func() {
+			r.quota, r.err = gv.GetQuota(ctx, dir)
+			r.at = time.Now()
+			close(r.done)
+		}()
+	}
+	qc.mu.Unlock()
+
+	select {
+	case <-r.done:
+		return r.quota, r.err
+	case <-time.After(wait):
+		return nil, fmt.Errorf("quota list still running after %v", wait)
+	}
+}
+
+// glusterCommand runs a gluster CLI command against the servers of the
+// volume, in failover order.
+func (gv *GlusterfsVolume) glusterCommand(ctx context.Context, args ...string) ([]byte, error) {
+	return gv.glusterCommandRetried(ctx, nil, args...)
+}
+
+// glusterCommandRetried is glusterCommand, for commands that may succeed on
+// a server without reporting it, like on a timeout: done tells from the
+// output of a failure whether the change was already done. It is an error
+// on the first server, and means an earlier attempt did it on the others.
+func (gv *GlusterfsVolume) glusterCommandRetried(ctx context.Context, done func(output string) bool, args ...string) ([]byte, error) {
+	servers := gv.servers()
+	if len(servers) == 0 {
+		return nil, errors.New("no server to run gluster command on")
+	}
+
+	var err error
+	for i, server := range servers {
+		output, cmdErr := ExecuteCommand(ctx, "gluster",
+			append([]string{"--mode=script", "--remote-host=" + server.Host}, args...)...)
+		if cmdErr == nil {
+			return output, nil
+		}
+		err = fmt.Errorf("gluster command execute failed: %w (%s)",
+			cmdErr, strings.TrimSpace(string(output)))
+		if done != nil && done(string(output)) {
+			if i > 0 {
+				return output, nil
+			}
+			break
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, err
+}
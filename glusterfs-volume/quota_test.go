@@ -0,0 +1,126 @@
+package glusterfsvolume
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParseQuotaSize(t *testing.T) {
+	cases := map[string]string{
+		"10G":     "10GB",
+		"512mb":   "512MB",
+		" 1TB ":   "1TB",
+		"1048576": "1048576",
+	}
+	for size, expected := range cases {
+		if parsed, err := ParseQuotaSize(size); err != nil || parsed != expected {
+			t.Errorf("'%v' parsed as '%v' ('%v'), expected '%v'", size, parsed, err, expected)
+		}
+	}
+
+	for _, size := range []string{"", "0", "10X", "1.5G", "-1G", "G"} {
+		if _, err := ParseQuotaSize(size); err == nil {
+			t.Errorf("Invalid size '%v' should return error", size)
+		}
+	}
+}
+
+const quotaListXML = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<cliOutput>
+  <opRet>0</opRet>
+  <opErrno>0</opErrno>
+  <opErrstr/>
+  <volQuota>
+    <limit>
+      <path>/test</path>
+      <hard_limit>10737418240</hard_limit>
+      <soft_limit_percent>80%</soft_limit_percent>
+      <soft_limit_value>8589934592</soft_limit_value>
+      <used_space>1048576</used_space>
+      <avail_space>10736369664</avail_space>
+      <sl_exceeded>No</sl_exceeded>
+      <hl_exceeded>No</hl_exceeded>
+    </limit>
+  </volQuota>
+</cliOutput>`
+
+func TestGetQuota(t *testing.T) {
+	var commands [][]string
+	defer func(e func(context.Context, string, ...string) ([]byte, error)) { ExecuteCommand = e }(ExecuteCommand)
+	ExecuteCommand = func(_ context.Context, cmd string, args ...string) ([]byte, error) {
+		commands = append(commands, append([]string{cmd}, args...))
+		if args[1] == "--remote-host=server1" {
+			return []byte("Connection failed."), errors.New("exit status 1")
+		}
+		return []byte(quotaListXML), nil
+	}
+
+	gv := GlusterfsVolume{Servers: "server1,server2:24008", VolumeName: "vol"}
+	quota, err := gv.GetQuota(context.Background(), "test")
+	if err != nil {
+		t.Fatalf("Unexpected error '%v'", err)
+	}
+	if expected := (Quota{Path: "/test", Limit: 10737418240, Used: 1048576, Available: 10736369664}); *quota != expected {
+		t.Errorf("Unexpected quota %#v", quota)
+	}
+	expected := [][]string{
+		{"gluster", "--mode=script", "--remote-host=server1", "volume", "quota", "vol", "list", "/test", "--xml"},
+		{"gluster", "--mode=script", "--remote-host=server2", "volume", "quota", "vol", "list", "/test", "--xml"},
+	}
+	if !reflect.DeepEqual(commands, expected) {
+		t.Errorf("Unexpected commands %v", commands)
+	}
+
+	if _, err := gv.GetQuota(context.Background(), "other"); err == nil {
+		t.Error("Missing quota should return error")
+	}
+}
+
+func TestQuotaCache(t *testing.T) {
+	var reads int32
+	release := make(chan struct{})
+	defer func(e func(context.Context, string, ...string) ([]byte, error)) { ExecuteCommand = e }(ExecuteCommand)
+	ExecuteCommand = func(_ context.Context, cmd string, args ...string) ([]byte, error) {
+		atomic.AddInt32(&reads, 1)
+		if args[len(args)-2] == "/hung" {
+			<-release
+		}
+		return []byte(quotaListXML), nil
+	}
+
+	qc := QuotaCache{TTL: time.Hour, Wait: 5 * time.Second}
+	gv := GlusterfsVolume{Servers: "server1", VolumeName: "vol"}
+	for i := 0; i < 3; i++ {
+		if quota, err := qc.Get(context.Background(), gv, "test"); err != nil || quota.Used != 1048576 {
+			t.Fatalf("Unexpected quota %#v, '%v'", quota, err)
+		}
+	}
+	if n := atomic.LoadInt32(&reads); n != 1 {
+		t.Errorf("Quota read %d times, expected once", n)
+	}
+
+	// reads of unanswered servers are bounded, and not repeated meanwhile.
+	qc.Wait = 10 * time.Millisecond
+	for i := 0; i < 2; i++ {
+		if _, err := qc.Get(context.Background(), gv, "hung"); err == nil || !strings.Contains(err.Error(), "still running") {
+			t.Errorf("Unexpected error '%v'", err)
+		}
+	}
+	if n := atomic.LoadInt32(&reads); n != 2 {
+		t.Errorf("Quota read %d times, expected twice", n)
+	}
+	close(release)
+
+	qc.TTL, qc.Wait = time.Nanosecond, 5*time.Second
+	if _, err := qc.Get(context.Background(), gv, "test"); err != nil {
+		t.Fatalf("Unexpected error '%v'", err)
+	}
+	if n := atomic.LoadInt32(&reads); n != 3 {
+		t.Errorf("Expired quota not read again, %d reads", n)
+	}
+}
@@ -0,0 +1,279 @@
+package glusterfsvolume
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultServerPort is the glusterd port, stripped from server specs.
+const DefaultServerPort = "24007"
+
+// ResolveTimeout bounds the DNS resolution of a server.
+var ResolveTimeout = 10 * time.Second
+
+// LookupHost resolves a host name to its addresses.
+var LookupHost = net.DefaultResolver.LookupHost
+
+// Server is a glusterd server, Port is empty for the default port.
+type Server struct {
+	Host string
+	Port string
+}
+
+// ParseServer parses a server spec: host, host:port, IPv6 address or
+// [IPv6 address]:port.
+func ParseServer(spec string) (Server, error) {
+	spec = strings.ToLower(strings.TrimSpace(spec))
+
+	var s Server
+	switch {
+	case strings.HasPrefix(spec, "["):
+		end := strings.Index(spec, "]")
+		if end < 0 {
+			return s, fmt.Errorf("invalid server '%v': missing ']'", spec)
+		}
+		s.Host = spec[1:end]
+		if rest := spec[end+1:]; rest != "" {
+			if !strings.HasPrefix(rest, ":") {
+				return s, fmt.Errorf("invalid server '%v'", spec)
+			}
+			if s.Port = rest[1:]; s.Port == "" {
+				return s, fmt.Errorf("invalid server '%v': missing port", spec)
+			}
+		}
+		if ip := net.ParseIP(s.Host); ip == nil || !s.IsIPv6() {
+			return s, fmt.Errorf("invalid server '%v': invalid IPv6 address", spec)
+		}
+	case strings.Count(spec, ":") > 1:
+		if net.ParseIP(spec) == nil {
+			return s, fmt.Errorf("invalid server '%v': invalid IPv6 address", spec)
+		}
+		s.Host = spec
+	default:
+		s.Host = spec
+		if i := strings.Index(spec, ":"); i >= 0 {
+			s.Host, s.Port = spec[:i], spec[i+1:]
+			if s.Port == "" {
+				return s, fmt.Errorf("invalid server '%v': missing port", spec)
+			}
+		}
+		s.Host = strings.TrimSuffix(s.Host, ".")
+		if s.Host == "" {
+			return s, fmt.Errorf("invalid server '%v': missing host", spec)
+		}
+		for _, c := range s.Host {
+			if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '.' || c == '-' || c == '_') {
+				return s, fmt.Errorf("invalid server '%v': invalid host name", spec)
+			}
+		}
+	}
+
+	if s.Port != "" {
+		port, err := strconv.Atoi(s.Port)
+		if err != nil || port < 1 || port > 65535 {
+			return s, fmt.Errorf("invalid server '%v': invalid port", spec)
+		}
+		s.Port = strconv.Itoa(port)
+		if s.Port == DefaultServerPort {
+			s.Port = ""
+		}
+	}
+	return s, nil
+}
+
+// ParseServers parses a comma separated list of servers and removes
+// duplicates, keeping the failover order.
+func ParseServers(servers string) ([]Server, error) {
+	var list []Server
+	seen := map[string]bool{}
+	for _, spec := range strings.Split(servers, ",") {
+		if strings.TrimSpace(spec) == "" {
+			continue
+		}
+		server, err := ParseServer(spec)
+		if err != nil {
+			return nil, err
+		}
+		if !seen[server.String()] {
+			seen[server.String()] = true
+			list = append(list, server)
+		}
+	}
+	return list, nil
+}
+
+func (s Server) IsIPv6() bool {
+	return strings.Contains(s.Host, ":")
+}
+
+// String returns the canonical spec of the server.
+func (s Server) String() string {
+	host := s.Host
+	if s.IsIPv6() {
+		host = "[" + host + "]"
+	}
+	if s.Port != "" {
+		host += ":" + s.Port
+	}
+	return host
+}
+
+// NormalizeServer returns the canonical form of a server spec: lower case,
+// without trailing dot nor default port.
+func NormalizeServer(spec string) string {
+	server, err := ParseServer(spec)
+	if err != nil {
+		return strings.ToLower(strings.TrimSpace(spec))
+	}
+	return server.String()
+}
+
+// NormalizeServers normalizes a comma separated list of servers and removes
+// duplicates, keeping the failover order.
+func NormalizeServers(servers string) string {
+	var list []string
+	seen := map[string]bool{}
+	for _, server := range strings.Split(servers, ",") {
+		server = NormalizeServer(server)
+		if server != "" && !seen[server] {
+			seen[server] = true
+			list = append(list, server)
+		}
+	}
+	return strings.Join(list, ",")
+}
+
+// ServerSet returns the sorted canonical servers of a comma separated list.
+// With resolve, host names are replaced by their addresses so that a host and
+// its IP compare equal.
+func ServerSet(servers string, resolve bool) ([]string, error) {
+	seen := map[string]bool{}
+	for _, server := range strings.Split(NormalizeServers(servers), ",") {
+		if server == "" {
+			continue
+		}
+		if !resolve {
+			seen[server] = true
+			continue
+		}
+		addrs, err := resolveServer(server)
+		if err != nil {
+			return nil, err
+		}
+		for _, addr := range addrs {
+			seen[addr] = true
+		}
+	}
+
+	set := make([]string, 0, len(seen))
+	for server := range seen {
+		set = append(set, server)
+	}
+	sort.Strings(set)
+	return set, nil
+}
+
+// ServerKey returns the key identifying a server set.
+func ServerKey(servers string, resolve bool) (string, error) {
+	set, err := ServerSet(servers, resolve)
+	if err != nil {
+		return "", err
+	}
+	return strings.Join(set, ","), nil
+}
+
+// ServerKeys are server keys resolved beforehand, see ResolveServerKeys.
+type ServerKeys struct {
+	keys map[string]string
+	errs map[string]error
+}
+
+// ResolveServerKeys returns the keys of the servers of config and of the
+// shared server lists, as returned by State.SharedServers. DNS lookups may
+// take up to ResolveTimeout each, so that it is meant to be called without
+// holding the lock guarding the State.
+func ResolveServerKeys(config Config, shared []string) ServerKeys {
+	k := ServerKeys{keys: map[string]string{}, errs: map[string]error{}}
+	for _, servers := range append(shared, NormalizeServers(config.Servers)) {
+		if _, ok := k.keys[servers]; ok || k.errs[servers] != nil {
+			continue
+		}
+		if key, err := ServerKey(servers, config.ResolveServers); err != nil {
+			k.errs[servers] = err
+		} else {
+			k.keys[servers] = key
+		}
+	}
+	return k
+}
+
+func (k ServerKeys) get(servers string) (string, error) {
+	if key, ok := k.keys[servers]; ok {
+		return key, nil
+	}
+	if err, ok := k.errs[servers]; ok {
+		return "", err
+	}
+	return "", fmt.Errorf("servers '%v' not resolved", servers)
+}
+
+// resolveServer returns the normalized addresses of a server.
+func resolveServer(spec string) ([]string, error) {
+	server, err := ParseServer(spec)
+	if err != nil {
+		return nil, err
+	}
+	if net.ParseIP(server.Host) != nil {
+		return []string{server.String()}, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), ResolveTimeout)
+	defer cancel()
+	addrs, err := LookupHost(ctx, server.Host)
+	if err != nil {
+		return nil, fmt.Errorf("error resolving server '%v': %v", spec, err)
+	}
+
+	for i, addr := range addrs {
+		addrs[i] = Server{Host: strings.ToLower(addr), Port: server.Port}.String()
+	}
+	return addrs, nil
+}
+
+// pathSafe turns a server key into a directory name: characters other than
+// letters, digits, '.', ',' and '-' are escaped as %XX, so that distinct keys
+// get distinct names. It never starts with '_', which is kept for non shared
+// mount IDs.
+func pathSafe(key string) string {
+	var b strings.Builder
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' ||
+			c == '.' || c == ',' || c == '-' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02x", c)
+		}
+	}
+	return b.String()
+}
+
+// legacyPathSafe is the former pathSafe, which mapped several keys to the
+// same name, kept to find the mounts named with it.
+func legacyPathSafe(key string) string {
+	return strings.Map(func(c rune) rune {
+		switch {
+		case c == '[' || c == ']':
+			return -1
+		case c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' ||
+			c == '.' || c == ',' || c == '-':
+			return c
+		}
+		return '_'
+	}, key)
+}
@@ -0,0 +1,298 @@
+package glusterfsvolume
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeServers(t *testing.T) {
+	cases := map[string]string{
+		"server1":                       "server1",
+		" Server1.Example.COM. ":        "server1.example.com",
+		"server1:24007":                 "server1",
+		"server1:24008":                 "server1:24008",
+		"[fe80::1]:24007":               "[fe80::1]",
+		"server2,SERVER1,server2:24007": "server2,server1",
+		"server1,,server2,":             "server1,server2",
+	}
+
+	for servers, expected := range cases {
+		if normalized := NormalizeServers(servers); normalized != expected {
+			t.Errorf("'%v' normalized to '%v', expected '%v'", servers, normalized, expected)
+		}
+	}
+}
+
+func TestServerSetResolve(t *testing.T) {
+	defer func(l func(context.Context, string) ([]string, error)) { LookupHost = l }(LookupHost)
+	LookupHost = func(ctx context.Context, host string) ([]string, error) {
+		return map[string][]string{
+			"server1": {"10.0.0.1"},
+			"server2": {"10.0.0.2", "fe80::2"},
+		}[host], nil
+	}
+
+	set, err := ServerSet("server2,10.0.0.1,server1:24007", true)
+	if err != nil {
+		t.Fatalf("Unexpected error '%v'", err)
+	}
+	if expected := []string{"10.0.0.1", "10.0.0.2", "[fe80::2]"}; !reflect.DeepEqual(set, expected) {
+		t.Errorf("Unexpected server set %v, expected %v", set, expected)
+	}
+}
+
+func TestGetOrCreateVolumeSharesServerSets(t *testing.T) {
+	s := State{
+		// created before IDs were derived from server sets.
+		"Server3,server1/legacy": {Servers: "Server3,server1", VolumeName: "legacy", Options: map[string]string{}},
+	}
+
+	cases := []struct {
+		servers string
+		volume  string
+		id      string
+	}{
+		{"server2,server1", "vol", "server1,server2/vol"},
+		{"SERVER1:24007,server2", "vol", "server1,server2/vol"},
+		{"server1,server3", "legacy", "Server3,server1/legacy"},
+	}
+
+	for _, c := range cases {
+		id, err := s.GetOrCreateVolume(Config{Servers: c.servers, VolumeName: c.volume}, "/root")
+		if err != nil {
+			t.Errorf("Unexpected error '%v'", err)
+			continue
+		}
+		if id != c.id {
+			t.Errorf("'%v' got ID '%v', expected '%v'", c.servers, id, c.id)
+		}
+	}
+
+	if len(s) != 2 {
+		t.Errorf("Unexpected volumes %v", s)
+	}
+	// failover order of the first creation is kept.
+	if gv := s["server1,server2/vol"]; gv.Servers != "server2,server1" {
+		t.Errorf("Unexpected servers '%v'", gv.Servers)
+	}
+}
+
+func TestGetOrCreateResolvedVolume(t *testing.T) {
+	defer func(l func(context.Context, string) ([]string, error)) { LookupHost = l }(LookupHost)
+	LookupHost = func(ctx context.Context, host string) ([]string, error) {
+		if host == "server1" {
+			return []string{"10.0.0.1"}, nil
+		}
+		return nil, errors.New("no such host")
+	}
+
+	s := State{"server1/legacy": {Servers: "server1", VolumeName: "legacy", Options: map[string]string{}}}
+	conf := Config{Servers: "10.0.0.1", VolumeName: "legacy", ResolveServers: true}
+	keys := ResolveServerKeys(conf, s.SharedServers())
+
+	// lookups are done by ResolveServerKeys only.
+	LookupHost = func(ctx context.Context, host string) ([]string, error) {
+		t.Errorf("Unexpected lookup of '%v'", host)
+		return nil, errors.New("no such host")
+	}
+	id, err := s.GetOrCreateResolvedVolume(conf, "/root", keys)
+	if err != nil {
+		t.Fatalf("Unexpected error '%v'", err)
+	}
+	if id != "server1/legacy" {
+		t.Errorf("Unexpected ID '%v'", id)
+	}
+
+	conf.Servers = "server2"
+	if _, err := s.GetOrCreateResolvedVolume(conf, "/root", keys); err == nil || !strings.Contains(err.Error(), "not resolved") {
+		t.Errorf("Unexpected error '%v' for servers not resolved", err)
+	}
+	LookupHost = func(ctx context.Context, host string) ([]string, error) {
+		return nil, errors.New("no such host")
+	}
+	keys = ResolveServerKeys(conf, nil)
+	if _, err := s.GetOrCreateResolvedVolume(conf, "/root", keys); err == nil || !strings.Contains(err.Error(), "no such host") {
+		t.Errorf("Unexpected error '%v' for servers failing to resolve", err)
+	}
+}
+
+func TestParseServer(t *testing.T) {
+	cases := map[string]Server{
+		"server1":             {Host: "server1"},
+		"server1:24008":       {Host: "server1", Port: "24008"},
+		"server1:024007":      {Host: "server1"},
+		"fe80::1":             {Host: "fe80::1"},
+		"[FE80::1]":           {Host: "fe80::1"},
+		"[fe80::1]:24008":     {Host: "fe80::1", Port: "24008"},
+		"192.168.1.10:24009":  {Host: "192.168.1.10", Port: "24009"},
+		"Server1.Example.com": {Host: "server1.example.com"},
+	}
+	for spec, expected := range cases {
+		server, err := ParseServer(spec)
+		if err != nil {
+			t.Errorf("Unexpected error parsing '%v': %v", spec, err)
+		} else if server != expected {
+			t.Errorf("'%v' parsed as %#v, expected %#v", spec, server, expected)
+		}
+	}
+
+	for _, spec := range []string{"server1:port", "server1:70000", "[fe80::1", "[server1]:24008", "fe80::zz", ":24008", "server/1", "server1:", "[fe80::1]:"} {
+		if _, err := ParseServer(spec); err == nil {
+			t.Errorf("Invalid server '%v' should return error", spec)
+		}
+	}
+}
+
+func TestGetMountArgsPortsAndIPv6(t *testing.T) {
+	gv := GlusterfsVolume{
+		Servers:       "server1:24008,[fe80::1]:24008,server2:24008,server3",
+		VolumeName:    "volume",
+		MountedVolume: MountedVolume{Mountpoint: "/mnt"},
+	}
+
+	cases := map[int][]string{
+		0: {"-t", "glusterfs", "server1:/volume", "/mnt",
+			"-o", "log-file=/run/docker/plugins/init-stdout",
+			"-o", "backup-volfile-servers=server2",
+			"-o", "volfile-server-port=24008"},
+		1: {"-t", "glusterfs", "fe80::1:/volume", "/mnt",
+			"-o", "log-file=/run/docker/plugins/init-stdout",
+			"-o", "backup-volfile-servers=server2:server1",
+			"-o", "volfile-server-port=24008",
+			"-o", "xlator-option=transport.address-family=inet6"},
+		3: {"-t", "glusterfs", "server3:/volume", "/mnt",
+			"-o", "log-file=/run/docker/plugins/init-stdout"},
+	}
+
+	for primary, args := range cases {
+		if !reflect.DeepEqual(gv.getMountArgs(primary), args) {
+			t.Errorf(
+				"incorrect command args\n %v\n expected\n %v",
+				gv.getMountArgs(primary), args)
+		}
+	}
+}
+
+func TestGetOrCreateVolumePathSafeId(t *testing.T) {
+	s := State{}
+
+	id, err := s.GetOrCreateVolume(Config{Servers: "[fe80::1]:24008,server1:24007", VolumeName: "vol"}, "/root")
+	if err != nil {
+		t.Fatalf("Unexpected error '%v'", err)
+	}
+	if id != "%5bfe80%3a%3a1%5d%3a24008,server1/vol" {
+		t.Errorf("Unexpected ID '%v'", id)
+	}
+	if gv := s[id]; gv.Servers != "[fe80::1]:24008,server1" || gv.Mountpoint != "/root/"+id {
+		t.Errorf("Unexpected volume %#v", gv)
+	}
+
+	// distinct server keys get distinct shared IDs, never starting with '_'.
+	ids := map[string]string{}
+	for _, servers := range []string{"host:1234", "host_1234", "::1", "[::1]:24008"} {
+		id, err := s.GetOrCreateVolume(Config{Servers: servers, VolumeName: "vol"}, "/root")
+		if err != nil {
+			t.Fatalf("Unexpected error '%v'", err)
+		}
+		if other, ok := ids[id]; ok || strings.HasPrefix(id, "_") {
+			t.Errorf("'%v' got ID '%v', shared with '%v'", servers, id, other)
+		}
+		ids[id] = servers
+	}
+
+	if _, err := s.GetOrCreateVolume(Config{Servers: "server1:http", VolumeName: "vol"}, "/root"); err == nil {
+		t.Error("Invalid server should return error")
+	}
+}
+
+func TestGetOrCreateVolumeLegacyPathSafeId(t *testing.T) {
+	// created when colons and underscores were both replaced by '_'.
+	s := State{
+		"_groups/stack/host_1234/vol": {Servers: "host:1234", VolumeName: "vol", Group: "stack", Options: map[string]string{}},
+		"__1/vol":                     {Servers: "::1", VolumeName: "vol", Options: map[string]string{}},
+	}
+
+	cases := []struct {
+		config Config
+		id     string
+	}{
+		{Config{Servers: "host:1234", VolumeName: "vol", MountGroup: "stack"}, "_groups/stack/host_1234/vol"},
+		{Config{Servers: "::1", VolumeName: "vol"}, "__1/vol"},
+		// not the legacy mount of another server.
+		{Config{Servers: "host_1234", VolumeName: "vol", MountGroup: "stack"}, "_groups/stack/host%5f1234/vol"},
+	}
+	for _, c := range cases {
+		id, err := s.GetOrCreateVolume(c.config, "/root")
+		if err != nil {
+			t.Fatalf("Unexpected error '%v'", err)
+		}
+		if id != c.id {
+			t.Errorf("'%v' got ID '%v', expected '%v'", c.config.Servers, id, c.id)
+		}
+	}
+}
+
+func TestGetOrCreateVolumeSharesOptions(t *testing.T) {
+	s := State{
+		// created before IDs included options.
+		"server1/legacy": {Servers: "server1", VolumeName: "legacy", Options: map[string]string{"acl": ""}},
+	}
+
+	cases := []struct {
+		volume  string
+		options map[string]string
+		id      string
+	}{
+		{"vol", nil, "server1/vol"},
+		{"vol", map[string]string{"acl": ""}, "server1/vol@" + OptionsHash(map[string]string{"acl": ""})},
+		{"vol", map[string]string{"acl": ""}, "server1/vol@" + OptionsHash(map[string]string{"acl": ""})},
+		{"vol", map[string]string{"log-level": "DEBUG"}, "server1/vol@" + OptionsHash(map[string]string{"log-level": "DEBUG"})},
+		{"legacy", map[string]string{"acl": ""}, "server1/legacy"},
+		{"legacy", nil, "server1/legacy@" + OptionsHash(nil)},
+	}
+
+	for _, c := range cases {
+		id, err := s.GetOrCreateVolume(Config{Servers: "server1", VolumeName: c.volume, Options: c.options}, "/root")
+		if err != nil {
+			t.Errorf("Unexpected error '%v'", err)
+			continue
+		}
+		if id != c.id {
+			t.Errorf("%v with options %v got ID '%v', expected '%v'", c.volume, c.options, id, c.id)
+		}
+	}
+
+	if len(s) != 5 {
+		t.Errorf("Unexpected volumes %v", s)
+	}
+}
+
+func TestGetOrCreateVolumeSubdir(t *testing.T) {
+	s := State{}
+
+	id, err := s.GetOrCreateVolume(Config{Servers: "server1", VolumeName: "vol", Subdir: "app"}, "/root")
+	if err != nil {
+		t.Fatalf("Unexpected error '%v'", err)
+	}
+	if id != "_subdirs/server1/vol/app" {
+		t.Errorf("Unexpected ID '%v'", id)
+	}
+	gv := s[id]
+	expected := []string{"-t", "glusterfs", "server1:/vol/app", "/root/" + id,
+		"-o", "log-file=/run/docker/plugins/init-stdout"}
+	if !reflect.DeepEqual(gv.getMountArgs(0), expected) {
+		t.Errorf("incorrect command args\n %v\n expected\n %v", gv.getMountArgs(0), expected)
+	}
+
+	for _, conf := range []Config{
+		{Servers: "server1", VolumeName: "vol", Subdir: "../app"},
+		{Servers: "server1", VolumeName: "vol", Subdir: "app", MountGroup: "stack"},
+	} {
+		if _, err := s.GetOrCreateVolume(conf, "/root"); err == nil {
+			t.Errorf("%#v should return error", conf)
+		}
+	}
+}
@@ -1,20 +1,38 @@
 package glusterfsvolume
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"path/filepath"
-	"reflect"
+	"sort"
+	"strconv"
+	"strings"
 )
 
 type State map[string]*GlusterfsVolume
 
 type Config struct {
 	DedicatedMount bool
+	// MountGroup is the name of a group of volumes sharing a private mount.
+	MountGroup string
+	// NativeSubdir mounts the subdir of docker volumes directly from
+	// gluster, as their own client.
+	NativeSubdir bool
+	// Subdir is the subdirectory of the gluster volume to mount.
+	Subdir string
+	// ResolveServers identifies servers by their addresses when sharing
+	// mounts.
+	ResolveServers bool
 
 	Servers    string
 	VolumeName string
-	Options    map[string]string
+	// Options are the mount options, those set at plugin level are defaults
+	// volumes can override.
+	Options map[string]string
+	// LockedOptions can not be overridden by volumes.
+	LockedOptions map[string]bool
 }
 
 func (c Config) Copy() Config {
@@ -28,26 +46,66 @@ func (c Config) Copy() Config {
 	return config
 }
 
-func CheckOption(key, val string) error {
-	switch key {
-	case "backup-volfile-server":
-		fallthrough
-	case "backup-volfile-servers":
-		return fmt.Errorf("'%v' option not supported", key)
-	case "log-file":
-		return fmt.Errorf("'%v' option not supported, logs are redirected to managed plugin stdout", key)
-	case "servers":
-		fallthrough
-	case "volume-name":
-		return fmt.Errorf("'%v' option not supported in options", key)
+// Override sets a volume option over the plugin level defaults, a flag set
+// by default is unset with the "false" value.
+func (c Config) Override(key, val string) error {
+	if c.LockedOptions[key] {
+		return fmt.Errorf("'%v' option locked by driver, can not override.", key)
 	}
+
+	if spec, ok := MountOptions[key]; ok && spec.Type == FlagOption && val == "false" {
+		delete(c.Options, key)
+		return nil
+	}
+
+	if err := CheckOption(key, val); err != nil {
+		return err
+	}
+	c.Options[key] = val
 	return nil
 }
 
+// ParseLockedOptions parses a space separated list of option names.
+func ParseLockedOptions(options string) (map[string]bool, error) {
+	locked := map[string]bool{}
+	for _, key := range strings.Fields(options) {
+		if _, ok := MountOptions[key]; !ok {
+			return nil, fmt.Errorf("unknown locked option '%v'", key)
+		}
+		locked[key] = true
+	}
+	return locked, nil
+}
+
+// FormatOptions returns mount options as passed to mount -o, sorted by name.
+func FormatOptions(options map[string]string) string {
+	var list []string
+	for key, val := range options {
+		if val != "" {
+			key += "=" + val
+		}
+		list = append(list, key)
+	}
+	sort.Strings(list)
+	return strings.Join(list, ",")
+}
+
 func (s State) GetOrCreateVolume(config Config, root string) (string, error) {
 	// returns an ID of a Volume, creates the volume if needed.
+	return s.getOrCreateVolume(config, root, func(servers string) (string, error) {
+		return ServerKey(servers, config.ResolveServers)
+	})
+}
+
+// GetOrCreateResolvedVolume is GetOrCreateVolume with the server keys
+// resolved beforehand by ResolveServerKeys, so that it does no DNS lookup.
+func (s State) GetOrCreateResolvedVolume(config Config, root string, keys ServerKeys) (string, error) {
+	return s.getOrCreateVolume(config, root, keys.get)
+}
+
+func (s State) getOrCreateVolume(config Config, root string, serverKeyOf func(servers string) (string, error)) (string, error) {
 	gv := &GlusterfsVolume{
-		Servers:    config.Servers,
+		Servers:    NormalizeServers(config.Servers),
 		VolumeName: config.VolumeName,
 		Options:    make(map[string]string),
 	}
@@ -66,26 +124,67 @@ func (s State) GetOrCreateVolume(config Config, root string) (string, error) {
 		return "", errors.New("'volume-name' option required")
 	}
 
-	id := ""
+	if _, err := ParseServers(gv.Servers); err != nil {
+		return "", err
+	}
+	serverKey, err := serverKeyOf(gv.Servers)
+	if err != nil {
+		return "", err
+	}
+
+	if config.MountGroup != "" {
+		if config.DedicatedMount {
+			return "", errors.New("'dedicated-mount' and 'mount-group' options are exclusive")
+		}
+		if !validName(config.MountGroup) {
+			return "", fmt.Errorf("invalid mount group name '%v'", config.MountGroup)
+		}
+		gv.Group = config.MountGroup
+	}
+
+	if config.Subdir != "" {
+		if config.MountGroup != "" {
+			return "", errors.New("'native-subdir' and 'mount-group' options are exclusive")
+		}
+		if !validName(config.Subdir) {
+			return "", fmt.Errorf("invalid subdir name '%v'", config.Subdir)
+		}
+		gv.Subdir = config.Subdir
+	}
+
+	base, id := "", ""
 	if config.DedicatedMount {
 		i := 1
 		for {
-			id = filepath.Join("_dedicated", gv.Servers, gv.VolumeName, string(i))
+			id = filepath.Join("_dedicated", pathSafe(serverKey), gv.VolumeName, strconv.Itoa(i))
 			if _, exists := s[id]; !exists {
 				break
 			}
 			i++
 		}
 	} else {
-		id = filepath.Join(gv.Servers, gv.VolumeName)
+		// mounts are shared by volumes with the same options.
+		base, id = s.sharedId(gv, pathSafe(serverKey))
+		if _, ok := s[id]; !ok {
+			// created before server keys were escaped injectively.
+			if _, legacyId := s.sharedId(gv, legacyPathSafe(serverKey)); s[legacyId] != nil {
+				if key, err := serverKeyOf(s[legacyId].Servers); err == nil && key == serverKey {
+					base, id = "", legacyId
+				}
+			}
+		}
+		if _, ok := s[id]; !ok && !strings.HasPrefix(base, "_") {
+			if existingId, ok := s.findShared(serverKey, gv.VolumeName, gv.Options, serverKeyOf); ok {
+				id = existingId
+			}
+		}
 	}
 	gv.Mountpoint = filepath.Join(root, id)
 	if existingVolume, ok := s[id]; ok {
-		if !reflect.DeepEqual(gv.Options, existingVolume.Options) {
+		if !sameOptions(gv.Options, existingVolume.Options) {
 			return "", fmt.Errorf(
-				"%#v options differ from already created volumes with options %#v"+
-					" use 'dedicated-mount' option to not reuse existing mounts.",
-				gv.Options, existingVolume.Options)
+				"%#v options differ from already created volume %v with options %#v",
+				gv.Options, id, existingVolume.Options)
 		}
 		gv = existingVolume
 	} else {
@@ -94,3 +193,79 @@ func (s State) GetOrCreateVolume(config Config, root string) (string, error) {
 
 	return id, nil
 }
+
+// sharedId returns the base ID and the ID of the mount of gv shared by
+// volumes with the same options, on servers named serverDir.
+func (s State) sharedId(gv *GlusterfsVolume, serverDir string) (string, string) {
+	base := filepath.Join(serverDir, gv.VolumeName)
+	if gv.Subdir != "" {
+		base = filepath.Join("_subdirs", base, gv.Subdir)
+	} else if gv.Group != "" {
+		base = filepath.Join("_groups", gv.Group, base)
+	}
+	id := base
+	if len(gv.Options) != 0 {
+		id += "@" + OptionsHash(gv.Options)
+	}
+	if existingVolume, ok := s[id]; ok && !sameOptions(gv.Options, existingVolume.Options) {
+		// created before IDs included options.
+		id = base + "@" + OptionsHash(gv.Options)
+	}
+	return base, id
+}
+
+// findShared looks for a shared volume on the same server set with the same
+// options, such as volumes created before IDs were derived from server sets.
+func (s State) findShared(serverKey, volumeName string, options map[string]string, serverKeyOf func(servers string) (string, error)) (string, bool) {
+	for id, gv := range s {
+		if strings.HasPrefix(id, "_") || gv.VolumeName != volumeName || !sameOptions(gv.Options, options) {
+			continue
+		}
+		if key, err := serverKeyOf(gv.Servers); err == nil && key == serverKey {
+			return id, true
+		}
+	}
+	return "", false
+}
+
+// SharedServers returns the server lists of the shared volumes, those
+// GetOrCreateVolume compares the keys of.
+func (s State) SharedServers() []string {
+	var servers []string
+	for id, gv := range s {
+		if !strings.HasPrefix(id, "_") {
+			servers = append(servers, gv.Servers)
+		}
+	}
+	return servers
+}
+
+// validName tells whether name is a single path element, as docker volume
+// names are.
+func validName(name string) bool {
+	for i, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' ||
+			i > 0 && (c == '.' || c == '-' || c == '_')) {
+			return false
+		}
+	}
+	return name != ""
+}
+
+func sameOptions(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for key, val := range a {
+		if other, ok := b[key]; !ok || other != val {
+			return false
+		}
+	}
+	return true
+}
+
+// OptionsHash returns a short hash identifying a set of mount options.
+func OptionsHash(options map[string]string) string {
+	sum := sha256.Sum256([]byte(FormatOptions(options)))
+	return hex.EncodeToString(sum[:6])
+}
@@ -0,0 +1,127 @@
+package glusterfsvolume
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Migration upgrades a decoded state to the next version.
+type Migration func(state map[string]interface{}) error
+
+// StateFile persists a versioned state as a JSON file.
+type StateFile struct {
+	Path string
+
+	// Version is the version of the state format currently written.
+	Version int
+	// Migrations[v] upgrades a state of version v to version v+1, versions
+	// without migration are upgraded as is.
+	Migrations map[int]Migration
+}
+
+func (sf StateFile) String() string {
+	return sf.Path
+}
+
+func (sf StateFile) Load(state interface{}) error {
+	data, err := ioutil.ReadFile(sf.Path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			logrus.WithField("statePath", sf.Path).Debug("no state found")
+			return nil
+		}
+		return err
+	}
+
+	raw := map[string]interface{}{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return fmt.Errorf("error decoding state '%v': %v", sf.Path, err)
+	}
+
+	if err := migrate(raw, sf.Version, sf.Migrations); err != nil {
+		return fmt.Errorf("error migrating state '%v': %v", sf.Path, err)
+	}
+
+	if data, err = json.Marshal(raw); err != nil {
+		return err
+	}
+	return json.Unmarshal(data, state)
+}
+
+func (sf StateFile) Save(state interface{}) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return WriteFileAtomic(sf.Path, data, 0644)
+}
+
+func migrate(state map[string]interface{}, version int, migrations map[int]Migration) error {
+	v := 0
+	if rawVersion, ok := state["Version"]; ok {
+		f, ok := rawVersion.(float64)
+		if !ok {
+			return fmt.Errorf("invalid version %#v", rawVersion)
+		}
+		v = int(f)
+	}
+
+	if v > version {
+		return fmt.Errorf("version %v is newer than supported version %v", v, version)
+	}
+
+	for ; v < version; v++ {
+		if m, ok := migrations[v]; ok {
+			logrus.Infof("migrating state from version %v to %v", v, v+1)
+			if err := m(state); err != nil {
+				return fmt.Errorf("migration from version %v failed: %v", v, err)
+			}
+		}
+	}
+	state["Version"] = version
+
+	return nil
+}
+
+// WriteFileAtomic writes data to a temporary file synced to disk and renames
+// it over path, so that path holds either the old or the new content.
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+
+	f, err := ioutil.TempFile(dir, "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	tmpPath := f.Name()
+	defer os.Remove(tmpPath)
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmpPath, perm); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return err
+	}
+
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
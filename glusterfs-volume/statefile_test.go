@@ -0,0 +1,97 @@
+package glusterfsvolume
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+type testState struct {
+	Version int
+	Volumes map[string]string
+}
+
+func TestStateFileSaveAndLoad(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "glusterfs-volume-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	sf := StateFile{Path: filepath.Join(tmpDir, "state.json"), Version: 1}
+	state := testState{Version: 1, Volumes: map[string]string{"a": "b"}}
+
+	if err := sf.Save(state); err != nil {
+		t.Fatalf("Unexpected error '%v'", err)
+	}
+
+	var loaded testState
+	if err := sf.Load(&loaded); err != nil {
+		t.Fatalf("Unexpected error '%v'", err)
+	}
+	if !reflect.DeepEqual(state, loaded) {
+		t.Errorf("Loaded state\n%#v\n differs from original state\n%#v", loaded, state)
+	}
+
+	files, _ := ioutil.ReadDir(tmpDir)
+	if len(files) != 1 {
+		t.Errorf("Temporary files left behind: %v", files)
+	}
+}
+
+func TestStateFileMissing(t *testing.T) {
+	sf := StateFile{Path: "/nonexistent/state.json", Version: 1}
+
+	state := testState{Volumes: map[string]string{}}
+	if err := sf.Load(&state); err != nil {
+		t.Errorf("Missing state file should not return error: %v", err)
+	}
+}
+
+func TestStateFileMigrations(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "glusterfs-volume-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	path := filepath.Join(tmpDir, "state.json")
+	if err := ioutil.WriteFile(path, []byte(`{"Names":["a"]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	sf := StateFile{
+		Path:    path,
+		Version: 2,
+		Migrations: map[int]Migration{
+			1: func(state map[string]interface{}) error {
+				volumes := map[string]interface{}{}
+				for _, name := range state["Names"].([]interface{}) {
+					volumes[name.(string)] = ""
+				}
+				state["Volumes"] = volumes
+				delete(state, "Names")
+				return nil
+			},
+		},
+	}
+
+	var state testState
+	if err := sf.Load(&state); err != nil {
+		t.Fatalf("Unexpected error '%v'", err)
+	}
+	expected := testState{Version: 2, Volumes: map[string]string{"a": ""}}
+	if !reflect.DeepEqual(state, expected) {
+		t.Errorf("Migrated state\n%#v\n expected\n%#v", state, expected)
+	}
+
+	sf.Version = 1
+	if err := sf.Save(expected); err != nil {
+		t.Fatalf("Unexpected error '%v'", err)
+	}
+	if err := sf.Load(&state); err == nil {
+		t.Error("Loading a state newer than supported should return error")
+	}
+}
@@ -0,0 +1,298 @@
+package glusterfsvolume
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/sirupsen/logrus"
+)
+
+// StateStore persists the state of a driver.
+type StateStore interface {
+	Load(state interface{}) error
+	Save(state interface{}) error
+}
+
+// StateUpdater is implemented by the stores shared by several hosts. Update
+// loads state, calls fn to change it and saves it, with the store locked
+// meanwhile so that changes from other hosts are not lost.
+type StateUpdater interface {
+	Update(state interface{}, fn func() error) error
+}
+
+// NewStateStore returns the store of kind: "local", the default, keeps the
+// state in file, "gluster" keeps it in the GlusterStore name of the gluster
+// volume of config, mounted on root/_state, with the version and migrations
+// of file.
+func NewStateStore(kind string, file StateFile, name, root string, config Config) (StateStore, error) {
+	switch kind {
+	case "", "local":
+		return file, nil
+	case "gluster":
+		if config.Servers == "" || config.VolumeName == "" {
+			return nil, errors.New("'state-store=gluster' option requires SERVERS and VOLUME_NAME to be set")
+		}
+		gv := &GlusterfsVolume{
+			Servers:       config.Servers,
+			VolumeName:    config.VolumeName,
+			Options:       config.Copy().Options,
+			MountedVolume: MountedVolume{Mountpoint: filepath.Join(root, "_state")},
+		}
+		return NewGlusterStore(gv, name, file.Version, file.Migrations), nil
+	}
+	return nil, fmt.Errorf("unknown state store '%v'", kind)
+}
+
+// SaveState saves state to store, logging failures.
+func SaveState(store StateStore, state interface{}) error {
+	logrus.WithField("method", "saveState").Debugf("saving state %#v to '%v'", state, store)
+
+	if err := store.Save(state); err != nil {
+		logrus.WithField("store", store).Error(err)
+		return fmt.Errorf("Error saving state: %v", err)
+	}
+	return nil
+}
+
+const storeDir = ".docker-volumes"
+const storeStateFile = "state.json"
+const storeLockFile = ".lock"
+
+// GlusterStore persists a state on a gluster volume, so that it is shared by
+// every host using that volume.
+//
+// Each entry of the top level maps of the state is stored in its own record
+// file, other top level fields are stored in a state.json file. Only records
+// changed since the last Load or Save are written, so that records of other
+// hosts are not overwritten.
+type GlusterStore struct {
+	Volume *GlusterfsVolume
+	Dir    string
+
+	Version    int
+	Migrations map[int]Migration
+
+	mu     sync.Mutex
+	synced map[string][]byte
+}
+
+// NewGlusterStore returns a store keeping records in the hidden directory
+// .docker-volumes/<name> of the gluster volume.
+func NewGlusterStore(gv *GlusterfsVolume, name string, version int, migrations map[int]Migration) *GlusterStore {
+	return &GlusterStore{
+		Volume:     gv,
+		Dir:        filepath.Join(gv.Mountpoint, storeDir, name),
+		Version:    version,
+		Migrations: migrations,
+		synced:     map[string][]byte{},
+	}
+}
+
+func (gs *GlusterStore) String() string {
+	return fmt.Sprintf("%v:/%v/%v", gs.Volume.Servers, gs.Volume.VolumeName,
+		strings.TrimPrefix(gs.Dir, gs.Volume.Mountpoint+"/"))
+}
+
+func (gs *GlusterStore) Load(state interface{}) error {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+
+	unlock, err := gs.lock(syscall.LOCK_SH)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return gs.load(state)
+}
+
+// load reads the state, the caller must hold the store locks.
+func (gs *GlusterStore) load(state interface{}) error {
+	raw := map[string]interface{}{}
+	synced := map[string][]byte{}
+
+	statePath := filepath.Join(gs.Dir, storeStateFile)
+	data, err := ioutil.ReadFile(statePath)
+	if err == nil {
+		if err := json.Unmarshal(data, &raw); err != nil {
+			return fmt.Errorf("error decoding state '%v': %v", statePath, err)
+		}
+		synced[statePath] = data
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	dirs, err := ioutil.ReadDir(gs.Dir)
+	if err != nil {
+		return err
+	}
+	for _, dir := range dirs {
+		if !dir.IsDir() {
+			continue
+		}
+		records := map[string]interface{}{}
+		files, err := ioutil.ReadDir(filepath.Join(gs.Dir, dir.Name()))
+		if err != nil {
+			return err
+		}
+		for _, f := range files {
+			if f.IsDir() || !strings.HasSuffix(f.Name(), ".json") {
+				continue
+			}
+			key, err := url.PathUnescape(strings.TrimSuffix(f.Name(), ".json"))
+			if err != nil {
+				logrus.WithField("store", gs.Dir).Warnf("Ignoring record '%v': %v", f.Name(), err)
+				continue
+			}
+			path := filepath.Join(gs.Dir, dir.Name(), f.Name())
+			data, err := ioutil.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			var record interface{}
+			if err := json.Unmarshal(data, &record); err != nil {
+				return fmt.Errorf("error decoding record '%v': %v", path, err)
+			}
+			records[key] = record
+			synced[path] = data
+		}
+		raw[dir.Name()] = records
+	}
+
+	if err := migrate(raw, gs.Version, gs.Migrations); err != nil {
+		return fmt.Errorf("error migrating state '%v': %v", gs.Dir, err)
+	}
+
+	if data, err = json.Marshal(raw); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, state); err != nil {
+		return err
+	}
+
+	gs.synced = synced
+	return nil
+}
+
+func (gs *GlusterStore) Save(state interface{}) error {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+
+	unlock, err := gs.lock(syscall.LOCK_EX)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return gs.save(state)
+}
+
+func (gs *GlusterStore) Update(state interface{}, fn func() error) error {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+
+	unlock, err := gs.lock(syscall.LOCK_EX)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := gs.load(state); err != nil {
+		return err
+	}
+	if err := fn(); err != nil {
+		return err
+	}
+	return gs.save(state)
+}
+
+// save writes the records changed since they were last synced, the caller
+// must hold the store locks.
+func (gs *GlusterStore) save(state interface{}) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	raw := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	files := map[string][]byte{}
+	top := map[string]json.RawMessage{}
+	for key, val := range raw {
+		if !bytes.HasPrefix(val, []byte("{")) {
+			top[key] = val
+			continue
+		}
+		records := map[string]json.RawMessage{}
+		if err := json.Unmarshal(val, &records); err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Join(gs.Dir, key), 0755); err != nil {
+			return err
+		}
+		for name, record := range records {
+			path := filepath.Join(gs.Dir, key, url.PathEscape(name)+".json")
+			files[path] = record
+		}
+	}
+	if files[filepath.Join(gs.Dir, storeStateFile)], err = json.Marshal(top); err != nil {
+		return err
+	}
+
+	for path, data := range files {
+		if bytes.Equal(gs.synced[path], data) {
+			continue
+		}
+		if err := WriteFileAtomic(path, data, 0644); err != nil {
+			return err
+		}
+		gs.synced[path] = data
+	}
+
+	for path := range gs.synced {
+		if _, ok := files[path]; ok {
+			continue
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		delete(gs.synced, path)
+	}
+
+	return nil
+}
+
+func (gs *GlusterStore) lock(how int) (func(), error) {
+	if err := gs.Volume.Mount(context.Background()); err != nil {
+		return nil, fmt.Errorf("error mounting state volume: %v", err)
+	}
+	if err := os.MkdirAll(gs.Dir, 0755); err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(filepath.Join(gs.Dir, storeLockFile), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(f.Fd()), how); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("error locking state '%v': %v", gs.Dir, err)
+	}
+
+	return func() {
+		syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		f.Close()
+	}, nil
+}
@@ -0,0 +1,127 @@
+package glusterfsvolume
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestGlusterStoreSharedRecords(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "glusterfs-volume-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	ExecuteCommand = func(_ context.Context, cmd string, args ...string) ([]byte, error) {
+		return []byte{}, nil
+	}
+
+	gv := &GlusterfsVolume{
+		Servers:       "server1",
+		VolumeName:    "volume",
+		MountedVolume: MountedVolume{Mountpoint: tmpDir},
+	}
+	hostA := NewGlusterStore(gv, "test", 1, nil)
+	hostB := NewGlusterStore(gv, "test", 1, nil)
+
+	stateA := testState{Version: 1, Volumes: map[string]string{"a": "1", "b/c": "2"}}
+	if err := hostA.Save(stateA); err != nil {
+		t.Fatalf("Unexpected error '%v'", err)
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, ".docker-volumes", "test", "Volumes", "b%2Fc.json")); err != nil {
+		t.Errorf("Record not stored in its own file: %v", err)
+	}
+
+	var stateB testState
+	if err := hostB.Load(&stateB); err != nil {
+		t.Fatalf("Unexpected error '%v'", err)
+	}
+	if !reflect.DeepEqual(stateA, stateB) {
+		t.Errorf("Loaded state\n%#v\n differs from saved state\n%#v", stateB, stateA)
+	}
+
+	delete(stateB.Volumes, "b/c")
+	if err := hostB.Save(stateB); err != nil {
+		t.Fatalf("Unexpected error '%v'", err)
+	}
+
+	// host A did not reload, its stale "b/c" record must not come back.
+	stateA.Volumes["d"] = "3"
+	if err := hostA.Save(stateA); err != nil {
+		t.Fatalf("Unexpected error '%v'", err)
+	}
+
+	stateB = testState{}
+	if err := hostB.Load(&stateB); err != nil {
+		t.Fatalf("Unexpected error '%v'", err)
+	}
+	expected := map[string]string{"a": "1", "d": "3"}
+	if !reflect.DeepEqual(stateB.Volumes, expected) {
+		t.Errorf("Unexpected records %#v, expected %#v", stateB.Volumes, expected)
+	}
+}
+
+func TestGlusterStoreUpdate(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "glusterfs-volume-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	ExecuteCommand = func(_ context.Context, cmd string, args ...string) ([]byte, error) {
+		return []byte{}, nil
+	}
+
+	gv := &GlusterfsVolume{
+		Servers:       "server1",
+		VolumeName:    "volume",
+		MountedVolume: MountedVolume{Mountpoint: tmpDir},
+	}
+	hostA := NewGlusterStore(gv, "test", 1, nil)
+	hostB := NewGlusterStore(gv, "test", 1, nil)
+
+	if err := hostA.Save(testState{Version: 1, Volumes: map[string]string{"a": "1"}}); err != nil {
+		t.Fatalf("Unexpected error '%v'", err)
+	}
+	var stateA testState
+	if err := hostA.Load(&stateA); err != nil {
+		t.Fatalf("Unexpected error '%v'", err)
+	}
+
+	// host B changes the record after host A loaded it.
+	var stateB testState
+	if err := hostB.Update(&stateB, func() error {
+		stateB.Volumes["a"] += ",b"
+		return nil
+	}); err != nil {
+		t.Fatalf("Unexpected error '%v'", err)
+	}
+
+	// host A updates the record from its current content.
+	stateA = testState{}
+	if err := hostA.Update(&stateA, func() error {
+		stateA.Volumes["a"] += ",a"
+		return nil
+	}); err != nil {
+		t.Fatalf("Unexpected error '%v'", err)
+	}
+
+	if err := hostB.Update(&stateB, func() error {
+		return errors.New("failed")
+	}); err == nil {
+		t.Error("Update error should be returned")
+	}
+
+	stateB = testState{}
+	if err := hostB.Load(&stateB); err != nil {
+		t.Fatalf("Unexpected error '%v'", err)
+	}
+	if expected := map[string]string{"a": "1,b,a"}; !reflect.DeepEqual(stateB.Volumes, expected) {
+		t.Errorf("Unexpected records %#v, expected %#v", stateB.Volumes, expected)
+	}
+}
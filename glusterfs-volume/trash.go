@@ -0,0 +1,147 @@
+package glusterfsvolume
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Policies applied to the data of removed volumes.
+const (
+	RetainData = "retain"
+	DeleteData = "delete"
+	// TrashData moves the data to the trash of the gluster volume, where it
+	// is deleted after a retention period.
+	TrashData = "trash"
+)
+
+// TrashDir is the trash directory, at the root of gluster volumes.
+const TrashDir = ".trash"
+
+const trashTimeFormat = "20060102T150405Z"
+
+// CheckRemovePolicy checks an 'on-remove' option value.
+func CheckRemovePolicy(policy string) error {
+	switch policy {
+	case RetainData, DeleteData, TrashData:
+		return nil
+	}
+	return fmt.Errorf("invalid 'on-remove' option '%v', expected retain, delete or trash", policy)
+}
+
+// RemoveData applies policy to path, a file or directory stored on the
+// gluster volume mounted on root.
+func RemoveData(root, path, policy string) error {
+	if _, err := os.Lstat(path); os.IsNotExist(err) {
+		return nil
+	}
+
+	switch policy {
+	case RetainData:
+		return nil
+	case DeleteData:
+		return os.RemoveAll(path)
+	case TrashData:
+		trash := filepath.Join(root, TrashDir)
+		if err := os.MkdirAll(trash, 0700); err != nil {
+			return err
+		}
+		name := time.Now().UTC().Format(trashTimeFormat) + "-" + filepath.Base(path)
+		return os.Rename(path, filepath.Join(trash, name))
+	}
+	return CheckRemovePolicy(policy)
+}
+
+// TrashedVolume records a gluster volume with data in its trash, so that the
+// trash is purged even while the gluster volume is not used anymore.
+type TrashedVolume struct {
+	Servers    string
+	VolumeName string
+	Options    map[string]string
+	// Trashed is when data was last trashed, the record can be dropped once
+	// it is older than the retention and the trash was purged.
+	Trashed time.Time
+}
+
+// NewTrashedVolume returns the record of data trashed now on gv.
+func NewTrashedVolume(gv *GlusterfsVolume) TrashedVolume {
+	return TrashedVolume{
+		Servers:    gv.Servers,
+		VolumeName: gv.VolumeName,
+		Options:    gv.Options,
+		Trashed:    time.Now().UTC(),
+	}
+}
+
+// Trashes are the gluster volumes with trashed data, by ID.
+type Trashes map[string]TrashedVolume
+
+// Record records data trashed now on the gluster volume gvId.
+func (t *Trashes) Record(gvId string, gv *GlusterfsVolume) {
+	if *t == nil {
+		*t = Trashes{}
+	}
+	(*t)[gvId] = NewTrashedVolume(gv)
+}
+
+// Copy returns a copy of the records.
+func (t Trashes) Copy() Trashes {
+	trashes := make(Trashes, len(t))
+	for id, tv := range t {
+		trashes[id] = tv
+	}
+	return trashes
+}
+
+// Drop removes the records of purged, unless data was trashed again since.
+func (t Trashes) Drop(purged Trashes) {
+	for id, tv := range purged {
+		if current, ok := t[id]; ok && current.Trashed.Equal(tv.Trashed) {
+			delete(t, id)
+		}
+	}
+}
+
+// Config returns the configuration mounting the whole trashed volume.
+func (tv TrashedVolume) Config(resolve bool) Config {
+	return Config{
+		Servers:        tv.Servers,
+		VolumeName:     tv.VolumeName,
+		Options:        tv.Options,
+		ResolveServers: resolve,
+	}.Copy()
+}
+
+// PurgeTrash deletes the data trashed for longer than retention from the
+// gluster volume mounted on root, and returns the number of entries deleted.
+func PurgeTrash(root string, retention time.Duration) (int, error) {
+	entries, err := ioutil.ReadDir(filepath.Join(root, TrashDir))
+	if os.IsNotExist(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	for _, entry := range entries {
+		i := strings.Index(entry.Name(), "-")
+		if i < 0 {
+			continue
+		}
+		trashed, err := time.Parse(trashTimeFormat, entry.Name()[:i])
+		if err != nil || time.Since(trashed) < retention {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(root, TrashDir, entry.Name())); err != nil {
+			logrus.WithField("trash", root).Warnf("Error purging '%v': %s", entry.Name(), err)
+			continue
+		}
+		purged++
+	}
+	return purged, nil
+}
@@ -0,0 +1,78 @@
+package glusterfsvolume
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRemoveData(t *testing.T) {
+	root, err := ioutil.TempDir("", "glusterfs-volume-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	for _, name := range []string{"retained", "deleted", "trashed"} {
+		if err := os.MkdirAll(filepath.Join(root, name, "data"), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for name, policy := range map[string]string{"retained": RetainData, "deleted": DeleteData, "trashed": TrashData} {
+		if err := RemoveData(root, filepath.Join(root, name), policy); err != nil {
+			t.Errorf("Unexpected error applying '%v': %v", policy, err)
+		}
+	}
+	if err := RemoveData(root, filepath.Join(root, "missing"), TrashData); err != nil {
+		t.Errorf("Unexpected error trashing missing data: %v", err)
+	}
+
+	entries, _ := ioutil.ReadDir(root)
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	if strings.Join(names, ",") != ".trash,retained" {
+		t.Errorf("Unexpected entries %v", names)
+	}
+	trashed, _ := ioutil.ReadDir(filepath.Join(root, TrashDir))
+	if len(trashed) != 1 || !strings.HasSuffix(trashed[0].Name(), "-trashed") {
+		t.Errorf("Unexpected trash %v", trashed)
+	}
+	if _, err := os.Stat(filepath.Join(root, TrashDir, trashed[0].Name(), "data")); err != nil {
+		t.Errorf("Trashed data missing: %v", err)
+	}
+}
+
+func TestPurgeTrash(t *testing.T) {
+	root, err := ioutil.TempDir("", "glusterfs-volume-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	old := time.Now().Add(-48 * time.Hour).UTC().Format(trashTimeFormat)
+	recent := time.Now().UTC().Format(trashTimeFormat)
+	for _, name := range []string{old + "-vol1", old + "-vol2.img", recent + "-vol3", "unknown"} {
+		if err := os.MkdirAll(filepath.Join(root, TrashDir, name), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	purged, err := PurgeTrash(root, 24*time.Hour)
+	if err != nil || purged != 2 {
+		t.Errorf("Unexpected result %v, '%v'", purged, err)
+	}
+	entries, _ := ioutil.ReadDir(filepath.Join(root, TrashDir))
+	if len(entries) != 2 {
+		t.Errorf("Unexpected trash %v", entries)
+	}
+
+	if purged, err := PurgeTrash(filepath.Join(root, "missing"), time.Hour); err != nil || purged != 0 {
+		t.Errorf("Unexpected result on missing trash %v, '%v'", purged, err)
+	}
+}
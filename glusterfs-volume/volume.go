@@ -1,19 +1,21 @@
 package glusterfsvolume
 
 import (
-	"bufio"
+	"context"
+	"errors"
 	"fmt"
 	"github.com/sirupsen/logrus"
 	"os"
-	"os/exec"
 	"strings"
 )
 
 type MountedVolume struct {
 	Mountpoint string
+
+	Health MountHealth `json:"-"`
 }
 
-func (mv *MountedVolume) Mount() error {
+func (mv *MountedVolume) Mount(ctx context.Context) error {
 	panic("Abstract method, provide an implementation.")
 }
 
@@ -58,70 +60,178 @@ func (mv *MountedVolume) DeleteMountpoint() error {
 }
 
 func (mv *MountedVolume) IsMounted() bool {
-	f, err := os.Open("/proc/mounts")
+	return mv.mountInfo() != nil
+}
+
+// mountInfo returns the mount table entry of the mount point, nil if not
+// mounted.
+func (mv *MountedVolume) mountInfo() *MountInfo {
+	m, err := FindMount(mv.Mountpoint)
 	if err != nil {
-		logrus.Errorf("Failed to open '/proc/mounts': %v", err)
-		return false
-	}
-	defer f.Close()
-	scanner := bufio.NewScanner(f)
-	for scanner.Scan() {
-		mount := strings.Split(scanner.Text(), " ")
-		if mount[1] == mv.Mountpoint {
-			return true
-		}
+		logrus.Errorf("Failed to read mount table '%v': %v", MountInfoPath, err)
+		return nil
 	}
-	return false
+	return m
 }
 
-func (mv *MountedVolume) Unmount() error {
+func (mv *MountedVolume) Unmount(ctx context.Context) error {
 	if !mv.IsMounted() {
 		logrus.Debugf("'%v' not mounted, so not unmounting", mv.Mountpoint)
 		return nil
 	}
 
-	output, err := ExecuteCommand("umount", mv.Mountpoint)
+	output, err := ExecuteCommand(ctx, "umount", mv.Mountpoint)
 	if err != nil {
-		return fmt.Errorf("umount command execute failed: %v (%s)", err, output)
+		return fmt.Errorf("umount command execute failed: %w (%s)", err, output)
 	}
 	return nil
 }
 
+const glusterfsType = "fuse.glusterfs"
+
 type GlusterfsVolume struct {
+	// Servers is a comma separated list of servers, the first one is the
+	// primary volfile server and the others its backups.
 	Servers    string
 	VolumeName string
 	Options    map[string]string
 
+	// Subdir is the subdirectory of the volume mounted, if any.
+	Subdir string `json:",omitempty"`
+	// Group is the mount group the volume is private to, if any.
+	Group string `json:",omitempty"`
+
+	// ActiveServer is the server the volume was mounted from.
+	ActiveServer string `json:"-"`
+
 	MountedVolume
 }
 
-var ExecuteCommand = func(cmd string, args ...string) ([]byte, error) {
-	return exec.Command(cmd, args...).CombinedOutput()
+// ServerList returns the servers of the volume, in failover order.
+func (gv *GlusterfsVolume) ServerList() []string {
+	var servers []string
+	for _, server := range gv.servers() {
+		servers = append(servers, server.String())
+	}
+	return servers
 }
 
-func (gv *GlusterfsVolume) Mount() error {
+func (gv *GlusterfsVolume) servers() []Server {
+	var servers []Server
+	for _, spec := range strings.Split(gv.Servers, ",") {
+		if spec = strings.TrimSpace(spec); spec == "" {
+			continue
+		}
+		server, err := ParseServer(spec)
+		if err != nil {
+			// let mount report it.
+			server = Server{Host: spec}
+		}
+		servers = append(servers, server)
+	}
+	return servers
+}
+
+// Mount mounts the volume and records the server it was mounted from in
+// ActiveServer.
+func (gv *GlusterfsVolume) Mount(ctx context.Context) error {
+	server, err := gv.MountServer(ctx)
+	if err == nil {
+		gv.ActiveServer = server
+	}
+	return err
+}
+
+// MountServer mounts the volume if needed and returns the server it is
+// mounted from. Each server is tried in turn as the primary volfile server,
+// the following ones being its backups.
+func (gv *GlusterfsVolume) MountServer(ctx context.Context) (string, error) {
 	if gv.IsMounted() {
-		return nil
+		return gv.mountedServer(), nil
 	}
 
 	if err := gv.CreateMountpoint(); err != nil {
-		return fmt.Errorf("error creating mount point: %v)", err)
+		return "", fmt.Errorf("error creating mount point: %v)", err)
 	}
 
-	args := gv.getMountArgs()
-	logrus.Debug(args)
+	servers := gv.ServerList()
+	if len(servers) == 0 {
+		return "", errors.New("no server to mount from")
+	}
+
+	var err error
+	for i, server := range servers {
+		args := gv.getMountArgs(i)
+		logrus.Debug(args)
+
+		output, mountErr := ExecuteCommand(ctx, "mount", args...)
+		if mountErr == nil {
+			return server, nil
+		}
+		err = fmt.Errorf("mount command execute failed: %w (%s)", mountErr, output)
+		if ctx.Err() != nil {
+			break
+		}
+		if i < len(servers)-1 {
+			logrus.WithField("mountpoint", gv.Mountpoint).Warnf(
+				"Mount from '%v' failed, trying next server: %v", server, err)
+		}
+	}
+	return "", err
+}
 
-	if output, err := ExecuteCommand("mount", args...); err != nil {
-		return fmt.Errorf("mount command execute failed: %v (%s)", err, output)
+// mountedServer returns the primary server of the current mount.
+func (gv *GlusterfsVolume) mountedServer() string {
+	m := gv.mountInfo()
+	if m == nil {
+		return gv.ActiveServer
 	}
-	return nil
+	i := strings.LastIndex(m.Source, ":/")
+	if i < 0 {
+		return gv.ActiveServer
+	}
+	host := strings.Trim(m.Source[:i], "[]")
+	// the source does not show the port.
+	for _, server := range gv.servers() {
+		if server.Host == host {
+			return server.String()
+		}
+	}
+	return Server{Host: host}.String()
 }
 
-func (gv *GlusterfsVolume) getMountArgs() []string {
-	volumefile := fmt.Sprintf("%v:/%v", gv.Servers, gv.VolumeName)
+// getMountArgs returns the mount arguments with the server of index primary
+// as primary volfile server, and the following ones as backups.
+// mount.glusterfs has a single volfile port and separates backup servers
+// with colons, so backups on another port or IPv6 addresses are left to the
+// failover of Mount.
+func (gv *GlusterfsVolume) getMountArgs(primary int) []string {
+	servers := gv.servers()
+	server := servers[primary]
+	var backups []string
+	for i := 1; i < len(servers); i++ {
+		backup := servers[(primary+i)%len(servers)]
+		if backup.Port == server.Port && !backup.IsIPv6() {
+			backups = append(backups, backup.Host)
+		}
+	}
+
+	volumefile := fmt.Sprintf("%v:/%v", server.Host, gv.VolumeName)
+	if gv.Subdir != "" {
+		volumefile += "/" + gv.Subdir
+	}
 	args := []string{
 		"-t", "glusterfs", volumefile, gv.Mountpoint,
 		"-o", "log-file=/run/docker/plugins/init-stdout"}
+	if len(backups) != 0 {
+		args = append(args, "-o", "backup-volfile-servers="+strings.Join(backups, ":"))
+	}
+	if server.Port != "" {
+		args = append(args, "-o", "volfile-server-port="+server.Port)
+	}
+	if server.IsIPv6() {
+		args = append(args, "-o", "xlator-option=transport.address-family=inet6")
+	}
 
 	for key, val := range gv.Options {
 		if val != "" {
@@ -134,8 +244,33 @@ func (gv *GlusterfsVolume) getMountArgs() []string {
 	return args
 }
 
+// Status returns the status reported in docker volume status, nil when
+// healthy, not mounted and without options.
+func (gv *GlusterfsVolume) Status() map[string]interface{} {
+	status := gv.Health.Status()
+	if status == nil && (gv.ActiveServer != "" || len(gv.Options) != 0 || gv.Group != "") {
+		status = map[string]interface{}{}
+	}
+	if gv.ActiveServer != "" && !gv.Health.Degraded() {
+		status["server"] = gv.ActiveServer
+	}
+	if len(gv.Options) != 0 {
+		status["options"] = FormatOptions(gv.Options)
+	}
+	if gv.Group != "" {
+		status["mount-group"] = gv.Group
+	}
+	return status
+}
+
 func (gv *GlusterfsVolume) IsMounted() bool {
-	if !gv.MountedVolume.IsMounted() {
+	m := gv.mountInfo()
+	if m == nil {
+		return false
+	}
+	if m.FSType != glusterfsType {
+		logrus.WithField("mountpoint", gv.Mountpoint).Warnf(
+			"'%v' mounted instead of %v", m.FSType, glusterfsType)
 		return false
 	}
 
@@ -143,7 +278,10 @@ func (gv *GlusterfsVolume) IsMounted() bool {
 	if err == nil {
 		return true
 	}
-	// force unmount as it seems stale.
-	gv.Unmount()
+	// force unmount as it seems stale, whatever the caller.
+	logrus.WithField("mountpoint", gv.Mountpoint).Warnf("stale mount, unmounting: %v", err)
+	if err := gv.ForceUnmount(context.Background()); err != nil {
+		logrus.WithField("mountpoint", gv.Mountpoint).Error(err)
+	}
 	return false
 }
@@ -1,6 +1,11 @@
 package glusterfsvolume
 
 import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
 )
@@ -46,10 +51,74 @@ func TestGetMountArgs(t *testing.T) {
 	}
 
 	for _, c := range cases {
-		if !reflect.DeepEqual(c.gv.getMountArgs(), c.args) {
+		if !reflect.DeepEqual(c.gv.getMountArgs(0), c.args) {
 			t.Errorf(
 				"incorrect command args\n %v\n expected\n %v",
-				c.gv.getMountArgs(), c.args)
+				c.gv.getMountArgs(0), c.args)
 		}
 	}
 }
+
+func TestGetMountArgsBackupServers(t *testing.T) {
+	gv := GlusterfsVolume{
+		Servers:       "server1, server2,server3",
+		VolumeName:    "volume",
+		MountedVolume: MountedVolume{Mountpoint: "/mnt"},
+	}
+
+	cases := map[int][]string{
+		0: {"-t", "glusterfs", "server1:/volume", "/mnt",
+			"-o", "log-file=/run/docker/plugins/init-stdout",
+			"-o", "backup-volfile-servers=server2:server3"},
+		2: {"-t", "glusterfs", "server3:/volume", "/mnt",
+			"-o", "log-file=/run/docker/plugins/init-stdout",
+			"-o", "backup-volfile-servers=server1:server2"},
+	}
+
+	for primary, args := range cases {
+		if !reflect.DeepEqual(gv.getMountArgs(primary), args) {
+			t.Errorf(
+				"incorrect command args\n %v\n expected\n %v",
+				gv.getMountArgs(primary), args)
+		}
+	}
+}
+
+func TestMountFailover(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "glusterfs-volume-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	MountInfoPath = filepath.Join(tmpDir, "mountinfo")
+	defer func() { MountInfoPath = "/proc/self/mountinfo" }()
+	if err := ioutil.WriteFile(MountInfoPath, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var primaries []string
+	defer func(e func(context.Context, string, ...string) ([]byte, error)) { ExecuteCommand = e }(ExecuteCommand)
+	ExecuteCommand = func(_ context.Context, cmd string, args ...string) ([]byte, error) {
+		primaries = append(primaries, args[2])
+		if args[2] == "server1:/volume" {
+			return []byte("failed to fetch volume file"), errors.New("exit status 1")
+		}
+		return []byte{}, nil
+	}
+
+	gv := GlusterfsVolume{
+		Servers:       "server1,server2,server3",
+		VolumeName:    "volume",
+		MountedVolume: MountedVolume{Mountpoint: filepath.Join(tmpDir, "mnt")},
+	}
+	if err := gv.Mount(context.Background()); err != nil {
+		t.Fatalf("Unexpected error '%v'", err)
+	}
+	if !reflect.DeepEqual(primaries, []string{"server1:/volume", "server2:/volume"}) {
+		t.Errorf("Unexpected mount attempts %v", primaries)
+	}
+	if gv.ActiveServer != "server2" {
+		t.Errorf("Unexpected active server '%v'", gv.ActiveServer)
+	}
+}